@@ -0,0 +1,352 @@
+package cfn
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Artifact is the content of a local file or directory that a packaged template
+// refers to, to be uploaded to S3.
+type Artifact struct {
+	// Path is the local path that the template refers to.
+	Path string
+	// Content is the content to upload, which for a directory is a .zip archive
+	// of the directory, and for a nested template is its packaged form.
+	Content []byte
+	// Ext is the file extension for the uploaded object, like ".zip".
+	Ext string
+	// Template indicates that the artifact is a nested template.
+	Template bool
+}
+
+// Location identifies an uploaded artifact.
+type Location struct {
+	Bucket string
+	Key    string
+	// URL is the HTTPS URL of the object.
+	URL string
+}
+
+// Uploader uploads an artifact to S3 and returns its location.
+type Uploader func(Artifact) (Location, error)
+
+// locationFormat describes how a property refers to an object in S3.
+type locationFormat int
+
+const (
+	// formatURL is an HTTPS URL string.
+	formatURL locationFormat = iota
+	// formatS3URI is an s3://bucket/key string.
+	formatS3URI
+	// formatBucketKey is a mapping with Bucket and Key properties.
+	formatBucketKey
+	// formatS3BucketKey is a mapping with S3Bucket and S3Key properties.
+	formatS3BucketKey
+)
+
+// packagedProperty describes a resource property that can refer to a local
+// path, which Package replaces with the location of the uploaded artifact.
+type packagedProperty struct {
+	ResourceType string
+	Property     string
+	Format       locationFormat
+	// Zip indicates that directories and files other than .zip or .jar archives
+	// should be uploaded as .zip archives.
+	Zip bool
+	// Template indicates that the property refers to a nested template, which
+	// should be packaged before it is uploaded.
+	Template bool
+}
+
+// packagedProperties are the properties that Package handles, which are a
+// subset of those handled by "aws cloudformation package".
+var packagedProperties = []packagedProperty{
+	{ResourceType: "AWS::CloudFormation::Stack", Property: "TemplateURL", Format: formatURL, Template: true},
+	{ResourceType: "AWS::Lambda::Function", Property: "Code", Format: formatS3BucketKey, Zip: true},
+	{ResourceType: "AWS::Lambda::LayerVersion", Property: "Content", Format: formatS3BucketKey, Zip: true},
+	{ResourceType: "AWS::Serverless::Function", Property: "CodeUri", Format: formatS3URI, Zip: true},
+	{ResourceType: "AWS::Serverless::LayerVersion", Property: "ContentUri", Format: formatS3URI, Zip: true},
+	{ResourceType: "AWS::StepFunctions::StateMachine", Property: "DefinitionS3Location", Format: formatBucketKey},
+	{ResourceType: "AWS::ApiGateway::RestApi", Property: "BodyS3Location", Format: formatBucketKey},
+	{ResourceType: "AWS::AppSync::GraphQLSchema", Property: "DefinitionS3Location", Format: formatS3URI},
+	{ResourceType: "AWS::AppSync::Resolver", Property: "RequestMappingTemplateS3Location", Format: formatS3URI},
+	{ResourceType: "AWS::AppSync::Resolver", Property: "ResponseMappingTemplateS3Location", Format: formatS3URI},
+	{ResourceType: "AWS::ElasticBeanstalk::ApplicationVersion", Property: "SourceBundle", Format: formatS3BucketKey, Zip: true},
+}
+
+// Package uploads the local files and directories that a template's resources
+// refer to, including nested templates, and returns the template with those
+// references replaced by the locations of the uploads. Relative paths are
+// relative to dir, which should be the directory containing the template.
+//
+// If the template refers to no local paths, Package returns the body unchanged.
+// Otherwise, the packaged template is always written in YAML form.
+func Package(body []byte, dir string, upload Uploader) ([]byte, error) {
+	p := packager{upload: upload, visiting: make(map[string]bool)}
+	return p.Package(body, dir)
+}
+
+type packager struct {
+	upload   Uploader
+	visiting map[string]bool
+}
+
+func (p *packager) Package(body []byte, dir string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("parsing template: template is not a mapping")
+	}
+
+	var changed bool
+	resources := mappingValue(doc.Content[0], "Resources")
+	for logicalID, resource := range mappingPairs(resources) {
+		resourceType := mappingValue(resource, "Type")
+		properties := mappingValue(resource, "Properties")
+		if resourceType == nil || properties == nil {
+			continue
+		}
+
+		for _, prop := range packagedProperties {
+			if prop.ResourceType != resourceType.Value {
+				continue
+			}
+			value := mappingValue(properties, prop.Property)
+			if value == nil || !isLocalPath(value) {
+				continue
+			}
+
+			location, err := p.uploadPath(prop, resolvePath(dir, value.Value))
+			if err != nil {
+				return nil, fmt.Errorf("packaging %s property of resource %s: %w", prop.Property, logicalID, err)
+			}
+			*value = *locationNode(prop.Format, location)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return body, nil
+	}
+
+	var packaged bytes.Buffer
+	encoder := yaml.NewEncoder(&packaged)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return packaged.Bytes(), nil
+}
+
+// uploadPath uploads the artifact at a local path for a packaged property.
+func (p *packager) uploadPath(prop packagedProperty, path string) (Location, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Location{}, err
+	}
+
+	artifact := Artifact{Path: path, Ext: filepath.Ext(path), Template: prop.Template}
+	switch {
+	case stat.IsDir() && !prop.Zip:
+		return Location{}, fmt.Errorf("%s is a directory", path)
+
+	case stat.IsDir():
+		artifact.Content, err = zipDir(path)
+		artifact.Ext = ".zip"
+
+	case prop.Zip && artifact.Ext != ".zip" && artifact.Ext != ".jar":
+		artifact.Content, err = zipFile(path, stat)
+		artifact.Ext = ".zip"
+
+	case prop.Template:
+		artifact.Content, err = p.packageNested(path)
+		if artifact.Ext == "" {
+			artifact.Ext = ".template"
+		}
+
+	default:
+		artifact.Content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return Location{}, err
+	}
+
+	return p.upload(artifact)
+}
+
+// packageNested packages the nested template at a local path.
+func (p *packager) packageNested(path string) ([]byte, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if p.visiting[absPath] {
+		return nil, fmt.Errorf("template %s refers to itself", path)
+	}
+	p.visiting[absPath] = true
+	defer delete(p.visiting, absPath)
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.Package(body, filepath.Dir(path))
+}
+
+// isLocalPath returns true if a property value looks like a local path rather
+// than an S3 location, URL, or intrinsic function.
+func isLocalPath(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode &&
+		node.ShortTag() == "!!str" &&
+		node.Value != "" &&
+		!strings.Contains(node.Value, "://")
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// locationNode returns a node that refers to an uploaded artifact in the form
+// that a property expects.
+func locationNode(format locationFormat, location Location) *yaml.Node {
+	scalar := func(value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	}
+	mapping := func(bucketName, keyName string) *yaml.Node {
+		return &yaml.Node{
+			Kind: yaml.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml.Node{
+				scalar(bucketName), scalar(location.Bucket),
+				scalar(keyName), scalar(location.Key),
+			},
+		}
+	}
+
+	switch format {
+	case formatS3URI:
+		return scalar("s3://" + location.Bucket + "/" + location.Key)
+	case formatBucketKey:
+		return mapping("Bucket", "Key")
+	case formatS3BucketKey:
+		return mapping("S3Bucket", "S3Key")
+	default:
+		return scalar(location.URL)
+	}
+}
+
+// mappingValue returns the value for a key in a mapping node, or nil if the
+// node is not a mapping or does not contain the key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for k, v := range mappingPairs(node) {
+		if k == key {
+			return v
+		}
+	}
+	return nil
+}
+
+// mappingPairs iterates over the keys and values of a mapping node.
+func mappingPairs(node *yaml.Node) iter.Seq2[string, *yaml.Node] {
+	return func(yield func(string, *yaml.Node) bool) {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !yield(node.Content[i].Value, node.Content[i+1]) {
+				return
+			}
+		}
+	}
+}
+
+// zipEpoch is the earliest time representable in a .zip archive, used as the
+// modification time of every archived file so that archives of unchanged files
+// are identical.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// zipDir returns a .zip archive of the regular files in a directory.
+func zipDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return addZipFile(archive, filepath.ToSlash(name), path, stat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zipFile returns a .zip archive containing a single file.
+func zipFile(path string, stat fs.FileInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	if err := addZipFile(archive, filepath.Base(path), path, stat); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addZipFile(archive *zip.Writer, name, path string, stat fs.FileInfo) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return AddZipFile(archive, name, file, stat.Mode()&0111 != 0)
+}
+
+// AddZipFile adds a file with the content of r to a .zip archive. The file has
+// a fixed modification time, and a mode of 0755 if executable or 0644 if not,
+// so that identical content always produces identical archives.
+func AddZipFile(archive *zip.Writer, name string, r io.Reader, executable bool) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: zipEpoch}
+	header.SetMode(0644)
+	if executable {
+		header.SetMode(0755)
+	}
+
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
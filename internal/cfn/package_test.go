@@ -0,0 +1,146 @@
+package cfn
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "layer", "bin", "tool"), "#!/bin/sh\n")
+	writeFile(t, filepath.Join(dir, "layer", "README"), "layer\n")
+	writeFile(t, filepath.Join(dir, "workflow.asl.json"), "{}\n")
+	writeFile(t, filepath.Join(dir, "nested", "nested.yaml"), `
+Resources:
+  Workflow:
+    Type: AWS::StepFunctions::StateMachine
+    Properties:
+      DefinitionS3Location: ../workflow.asl.json
+`)
+
+	const body = `
+Resources:
+  Layer:
+    Type: AWS::Lambda::LayerVersion
+    Properties:
+      Content: layer
+  Nested:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: nested/nested.yaml
+  Remote:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: !Sub "https://example.s3.amazonaws.com/${AWS::Region}.yaml"
+`
+
+	uploads := make(map[string]Artifact)
+	upload := func(artifact Artifact) (Location, error) {
+		key := filepath.Base(artifact.Path) + artifact.Ext
+		uploads[key] = artifact
+		return Location{Bucket: "bucket", Key: key, URL: "https://bucket/" + key}, nil
+	}
+
+	packaged, err := Package([]byte(body), dir, upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want any
+	if err := yaml.Unmarshal(packaged, &got); err != nil {
+		t.Fatal(err)
+	}
+	err = yaml.Unmarshal([]byte(`
+Resources:
+  Layer:
+    Type: AWS::Lambda::LayerVersion
+    Properties:
+      Content: {S3Bucket: bucket, S3Key: layer.zip}
+  Nested:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: https://bucket/nested.yaml.yaml
+  Remote:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: "https://example.s3.amazonaws.com/${AWS::Region}.yaml"
+`), &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected packaged template (-want +got):\n%s", diff)
+	}
+	if !bytes.Contains(packaged, []byte(`!Sub "https://example`)) {
+		t.Errorf("packaged template lost short form function:\n%s", packaged)
+	}
+
+	nested := string(uploads["nested.yaml.yaml"].Content)
+	if !strings.Contains(nested, "Bucket: bucket") || !strings.Contains(nested, "Key: workflow.asl.json.json") {
+		t.Errorf("nested template was not packaged:\n%s", nested)
+	}
+
+	layer := uploads["layer.zip"].Content
+	archive, err := zip.NewReader(bytes.NewReader(layer), int64(len(layer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"README", "bin/tool"}, names); diff != "" {
+		t.Errorf("unexpected layer archive contents (-want +got):\n%s", diff)
+	}
+}
+
+func TestPackageUnchanged(t *testing.T) {
+	body := []byte(yamlTemplate)
+	packaged, err := Package(body, t.TempDir(), func(Artifact) (Location, error) {
+		t.Fatal("unexpected upload")
+		return Location{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, packaged) {
+		t.Errorf("template without local paths was changed:\n%s", packaged)
+	}
+}
+
+func TestPackageCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "self.yaml"), `
+Resources:
+  Self:
+    Type: AWS::CloudFormation::Stack
+    Properties:
+      TemplateURL: self.yaml
+`)
+
+	body, err := os.ReadFile(filepath.Join(dir, "self.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Package(body, dir, func(Artifact) (Location, error) { return Location{}, nil })
+	if err == nil {
+		t.Error("packaged a template that refers to itself")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package cfn reads and packages AWS CloudFormation templates locally, without
+// the help of the CloudFormation API.
+package cfn
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Template represents the parts of a CloudFormation template that hfc checks
+// before deploying it.
+type Template struct {
+	Parameters map[string]Parameter
+}
+
+// Parameter represents the declaration of a template parameter.
+type Parameter struct {
+	Type                  string   `yaml:"Type"`
+	Default               *string  `yaml:"Default"`
+	AllowedValues         []string `yaml:"AllowedValues"`
+	AllowedPattern        string   `yaml:"AllowedPattern"`
+	MinLength             string   `yaml:"MinLength"`
+	MaxLength             string   `yaml:"MaxLength"`
+	MinValue              string   `yaml:"MinValue"`
+	MaxValue              string   `yaml:"MaxValue"`
+	ConstraintDescription string   `yaml:"ConstraintDescription"`
+}
+
+// ParseTemplate parses a template in either YAML or JSON form.
+//
+// YAML templates may use the short form of intrinsic functions, like !Ref or
+// !Sub, anywhere outside of the Parameters section.
+func ParseTemplate(body []byte) (*Template, error) {
+	// Decoding into a node, rather than a map, leaves any short form tags
+	// unresolved instead of failing on them.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("parsing template: template is not a mapping")
+	}
+
+	template := &Template{Parameters: make(map[string]Parameter)}
+	if parameters := mappingValue(doc.Content[0], "Parameters"); parameters != nil {
+		if err := parameters.Decode(&template.Parameters); err != nil {
+			return nil, fmt.Errorf("parsing template parameters: %w", err)
+		}
+	}
+	return template, nil
+}
+
+// CheckDeclared returns a non-nil error if the template does not declare every
+// one of the named parameters.
+func (t *Template) CheckDeclared(names []string) error {
+	var errs []error
+	for _, name := range sortedUnique(names) {
+		if _, ok := t.Parameters[name]; !ok {
+			errs = append(errs, fmt.Errorf("parameter %s is not declared by the template", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckRequired returns a non-nil error if the template declares any parameter
+// without a default value that is not one of the named parameters.
+func (t *Template) CheckRequired(names []string) error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(t.Parameters)) {
+		if t.Parameters[name].Default == nil && !slices.Contains(names, name) {
+			errs = append(errs, fmt.Errorf("parameter %s requires a value", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckValues returns a non-nil error if any of the provided values for
+// declared parameters fails to meet the constraints in its declaration.
+// Values for undeclared parameters are ignored.
+func (t *Template) CheckValues(values map[string]string) error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		declared, ok := t.Parameters[name]
+		if !ok {
+			continue
+		}
+		if err := declared.Check(values[name]); err != nil {
+			errs = append(errs, fmt.Errorf("parameter %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check returns a non-nil error if the value fails to meet the constraints in
+// the parameter's declaration.
+//
+// Constraints on list types apply to each value in the list. Constraints that
+// can't be checked locally are left for CloudFormation to check.
+func (p Parameter) Check(value string) error {
+	elementType, isList := strings.CutPrefix(p.Type, "List<")
+	switch {
+	case isList:
+		elementType = strings.TrimSuffix(elementType, ">")
+	case p.Type == "CommaDelimitedList":
+		elementType, isList = "String", true
+	default:
+		elementType = p.Type
+	}
+
+	elements := []string{value}
+	if isList {
+		elements = strings.Split(value, ",")
+	}
+	for _, element := range elements {
+		if isList {
+			element = strings.TrimSpace(element)
+		}
+		if err := p.checkElement(elementType, element); err != nil {
+			if p.ConstraintDescription != "" {
+				return fmt.Errorf("%w (%s)", err, p.ConstraintDescription)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p Parameter) checkElement(elementType, value string) error {
+	if len(p.AllowedValues) > 0 && !slices.Contains(p.AllowedValues, value) {
+		return fmt.Errorf("value %q is not one of the allowed values %q", value, p.AllowedValues)
+	}
+
+	switch elementType {
+	case "String":
+		if p.AllowedPattern != "" {
+			// CloudFormation patterns must match the entire value. Go regular
+			// expressions aren't quite the same as Java's, so any pattern that
+			// doesn't compile is simply left for CloudFormation to check.
+			if pattern, err := regexp.Compile(`^(?:` + p.AllowedPattern + `)$`); err == nil && !pattern.MatchString(value) {
+				return fmt.Errorf("value %q does not match the allowed pattern %q", value, p.AllowedPattern)
+			}
+		}
+		length := float64(len([]rune(value)))
+		if limit, ok := parseLimit(p.MinLength); ok && length < limit {
+			return fmt.Errorf("value %q is shorter than the minimum length %s", value, p.MinLength)
+		}
+		if limit, ok := parseLimit(p.MaxLength); ok && length > limit {
+			return fmt.Errorf("value %q is longer than the maximum length %s", value, p.MaxLength)
+		}
+
+	case "Number":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("value %q is not a number", value)
+		}
+		if limit, ok := parseLimit(p.MinValue); ok && number < limit {
+			return fmt.Errorf("value %s is less than the minimum value %s", value, p.MinValue)
+		}
+		if limit, ok := parseLimit(p.MaxValue); ok && number > limit {
+			return fmt.Errorf("value %s is greater than the maximum value %s", value, p.MaxValue)
+		}
+	}
+	return nil
+}
+
+func parseLimit(s string) (float64, bool) {
+	limit, err := strconv.ParseFloat(s, 64)
+	return limit, err == nil
+}
+
+func sortedUnique(names []string) []string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
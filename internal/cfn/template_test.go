@@ -0,0 +1,161 @@
+package cfn
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const yamlTemplate = `
+AWSTemplateFormatVersion: "2010-09-09"
+
+Parameters:
+  Environment:
+    Type: String
+    AllowedValues: [staging, production]
+  Name:
+    Type: String
+    AllowedPattern: "[a-z]+"
+    MinLength: 2
+    MaxLength: 8
+    ConstraintDescription: must be a short lowercase name
+  MemorySize:
+    Type: Number
+    Default: 128
+    MinValue: 128
+    MaxValue: 1024
+  Subnets:
+    Type: List<AWS::EC2::Subnet::Id>
+    AllowedValues: [subnet-a, subnet-b]
+
+Resources:
+  Function:
+    Type: AWS::Lambda::Function
+    Properties:
+      FunctionName: !Sub "${AWS::StackName}-${Name}"
+      MemorySize: !Ref MemorySize
+`
+
+const jsonTemplate = `{
+  "Parameters": {
+    "Environment": {"Type": "String", "AllowedValues": ["staging", "production"]},
+    "Name": {"Type": "String", "AllowedPattern": "[a-z]+", "MinLength": 2, "MaxLength": "8",
+      "ConstraintDescription": "must be a short lowercase name"},
+    "MemorySize": {"Type": "Number", "Default": "128", "MinValue": 128, "MaxValue": 1024},
+    "Subnets": {"Type": "List<AWS::EC2::Subnet::Id>", "AllowedValues": ["subnet-a", "subnet-b"]}
+  },
+  "Resources": {}
+}`
+
+func TestParseTemplate(t *testing.T) {
+	want := &Template{
+		Parameters: map[string]Parameter{
+			"Environment": {
+				Type:          "String",
+				AllowedValues: []string{"staging", "production"},
+			},
+			"Name": {
+				Type:                  "String",
+				AllowedPattern:        "[a-z]+",
+				MinLength:             "2",
+				MaxLength:             "8",
+				ConstraintDescription: "must be a short lowercase name",
+			},
+			"MemorySize": {
+				Type:     "Number",
+				Default:  ptr("128"),
+				MinValue: "128",
+				MaxValue: "1024",
+			},
+			"Subnets": {
+				Type:          "List<AWS::EC2::Subnet::Id>",
+				AllowedValues: []string{"subnet-a", "subnet-b"},
+			},
+		},
+	}
+
+	for name, body := range map[string]string{"YAML": yamlTemplate, "JSON": jsonTemplate} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseTemplate([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckDeclared(t *testing.T) {
+	template, err := ParseTemplate([]byte(yamlTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := template.CheckDeclared([]string{"Environment", "Name", "Environment"}); err != nil {
+		t.Errorf("unexpected error for declared parameters: %v", err)
+	}
+
+	err = template.CheckDeclared([]string{"Enviroment", "Name", "MemroySize"})
+	const want = "parameter Enviroment is not declared by the template\n" +
+		"parameter MemroySize is not declared by the template"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error; got %v, want %q", err, want)
+	}
+}
+
+func TestCheckRequired(t *testing.T) {
+	template, err := ParseTemplate([]byte(yamlTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := template.CheckRequired([]string{"Environment", "Name", "Subnets"}); err != nil {
+		t.Errorf("unexpected error for supplied parameters: %v", err)
+	}
+
+	err = template.CheckRequired([]string{"Name", "MemorySize"})
+	const want = "parameter Environment requires a value\n" +
+		"parameter Subnets requires a value"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error; got %v, want %q", err, want)
+	}
+}
+
+func TestCheckValues(t *testing.T) {
+	template, err := ParseTemplate([]byte(yamlTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name      string
+		Value     string
+		WantError bool
+	}{
+		{"Environment", "staging", false},
+		{"Environment", "development", true},
+		{"Name", "hfc", false},
+		{"Name", "HFC", true},
+		{"Name", "h", true},
+		{"Name", "hfchfchfc", true},
+		{"MemorySize", "512", false},
+		{"MemorySize", "64", true},
+		{"MemorySize", "2048", true},
+		{"MemorySize", "lots", true},
+		{"Subnets", "subnet-a, subnet-b", false},
+		{"Subnets", "subnet-a,subnet-c", true},
+		{"Undeclared", "anything", false},
+	}
+	for _, tc := range testCases {
+		err := template.CheckValues(map[string]string{tc.Name: tc.Value})
+		if (err != nil) != tc.WantError {
+			t.Errorf("%s=%q: got error %v, want error %v", tc.Name, tc.Value, err, tc.WantError)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -12,6 +12,8 @@ var buildDeployCmd = &cobra.Command{
 }
 
 func init() {
+	buildDeployCmd.Flags().BoolVarP(&deployYes, "yes", "y", false, "deploy protected stacks without confirmation")
+	buildDeployCmd.Flags().BoolVar(&deployIgnoreDrift, "ignore-drift", false, "skip the drift check for stacks that enable it")
 	rootCmd.AddCommand(buildDeployCmd)
 }
 
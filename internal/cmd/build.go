@@ -1,22 +1,33 @@
 package cmd
 
 import (
+	"cmp"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/ahamlinman/hfc/internal/config"
 	"github.com/ahamlinman/hfc/internal/shelley"
 )
 
 var buildCmd = &cobra.Command{
-	Use:    "build",
-	Short:  "Build the Go binary for Lambda",
-	PreRun: initializePreRun,
-	Run:    runBuild,
+	Use:   "build [stack]",
+	Short: "Build the Go binary for Lambda",
+	Long: `Build the Go binary for Lambda
+
+The build command builds for the architecture in the hfc build configuration,
+or for the architecture of the named stack if one is provided.
+`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runBuild,
 }
 
 func init() {
@@ -24,12 +35,27 @@ func init() {
 }
 
 func runBuild(cmd *cobra.Command, args []string) {
-	outputPath, err := rootState.BinaryPath(rootConfig.Project.Name)
-	if err != nil {
-		log.Fatal(err)
+	functions := rootConfig.Build.AllFunctions()
+
+	arch := rootConfig.BuildArch()
+	if len(args) > 0 {
+		stack, ok := rootConfig.FindStack(args[0])
+		if !ok {
+			log.Fatalf("stack %s is not configured", args[0])
+		}
+		arch = rootConfig.StackArch(stack)
+	}
+
+	outputPaths := make([]string, len(functions))
+	for i, fn := range functions {
+		var err error
+		outputPaths[i], err = functionBinaryPath(fn)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	outputDir := filepath.Dir(outputPath)
+	outputDir := filepath.Dir(outputPaths[0])
 	if err := os.RemoveAll(outputDir); err != nil {
 		log.Fatal("cleaning output directory: ", err)
 	}
@@ -37,21 +63,39 @@ func runBuild(cmd *cobra.Command, args []string) {
 		log.Fatal("creating output directory: ", err)
 	}
 
+	var group errgroup.Group
+	for i, fn := range functions {
+		group.Go(func() error { return buildBinary(fn, outputPaths[i], arch) })
+	}
+	shelley.ExitIfError(group.Wait())
+}
+
+// functionBinaryPath returns the relative path to the Go binary for a function
+// in the state directory.
+func functionBinaryPath(fn config.FunctionConfig) (string, error) {
+	return rootState.BinaryPath(cmp.Or(fn.Name, rootConfig.Project.Name))
+}
+
+// buildBinary builds the Go binary for a Lambda function at outputPath, adding
+// any extra flags to the go build command.
+func buildBinary(fn config.FunctionConfig, outputPath string, arch config.Arch, extraFlags ...string) error {
 	var tags strings.Builder
 	tags.WriteString("lambda.norpc")
-	for _, tag := range rootConfig.Build.Tags {
+	for _, tag := range slices.Concat(rootConfig.Build.Tags, fn.Tags) {
 		tags.WriteRune(',')
 		tags.WriteString(tag)
 	}
 
-	shelley.ExitIfError(shelley.
-		Command(
-			"go", "build", "-v",
-			"-ldflags", "-s -w",
-			"-tags", tags.String(),
-			"-o", outputPath,
-			rootConfig.Build.Path,
-		).
-		Env("CGO_ENABLED", "0").Env("GOOS", "linux").Env("GOARCH", "arm64").
-		Run())
+	args := append([]string{"go", "build", "-v"}, extraFlags...)
+	if rootConfig.Build.Reproducible {
+		// Strip local file system paths and the linker's build ID from the binary.
+		args = append(args, "-trimpath", "-ldflags", "-s -w -buildid=")
+	} else {
+		args = append(args, "-ldflags", "-s -w")
+	}
+	args = append(args, "-tags", tags.String(), "-o", outputPath, fn.Path)
+
+	return shelley.Command(args...).
+		Env("CGO_ENABLED", "0").Env("GOOS", "linux").Env("GOARCH", arch.GOARCH()).
+		Run()
 }
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/samber/lo"
+)
+
+const (
+	changeSetCreateTimeout = 30 * time.Minute
+	stackDeployTimeout     = 2 * time.Hour
+)
+
+// changeSetInput describes the stack update that a change set should perform.
+type changeSetInput struct {
+	StackName string
+	// Parameters are the values to set for the template's parameters. Any
+	// parameter declared by the template but missing from Parameters keeps its
+	// previous value if the stack has one, or falls back to the template's
+	// default.
+	Parameters map[string]string
+	// UsePreviousTemplate deploys the stack's current template rather than the
+	// configured template.
+	UsePreviousTemplate bool
+}
+
+// changeSet represents a change set that CloudFormation has finished computing.
+type changeSet struct {
+	ID        string
+	StackName string
+	Type      types.ChangeSetType
+	// PreviousParameters are the stack's parameter values from before the change
+	// set, if the stack already existed.
+	PreviousParameters map[string]string
+	// Empty indicates that the change set would not change the stack. An empty
+	// change set can't be executed, but should still be deleted.
+	Empty bool
+}
+
+// createChangeSet creates a change set to deploy the configured template to a
+// stack, and waits for CloudFormation to finish computing it. The change set
+// creates the stack if it does not already exist, and applies the tags, service
+// role, and notification ARNs in the stack's settings.
+func createChangeSet(ctx context.Context, cfnClient *cloudformation.Client, input changeSetInput) (changeSet, error) {
+	changeSetType := types.ChangeSetTypeUpdate
+	stack, err := describeStack(ctx, cfnClient, input.StackName)
+	switch {
+	case errors.Is(err, errStackNotExist):
+		changeSetType = types.ChangeSetTypeCreate
+	case err != nil:
+		return changeSet{}, err
+	case stack.StackStatus == types.StackStatusReviewInProgress:
+		// The stack was created by an earlier change set that was never executed.
+		changeSetType = types.ChangeSetTypeCreate
+	}
+
+	var (
+		summaryInput cloudformation.GetTemplateSummaryInput
+		createInput  cloudformation.CreateChangeSetInput
+	)
+	if input.UsePreviousTemplate {
+		summaryInput.StackName = aws.String(input.StackName)
+		createInput.UsePreviousTemplate = aws.Bool(true)
+	} else {
+		templateBody, err := readTemplateBody(input.StackName)
+		if err != nil {
+			return changeSet{}, err
+		}
+		templateBody, err = packageTemplate(ctx, templateBody)
+		if err != nil {
+			return changeSet{}, err
+		}
+		if len(templateBody) > maxTemplateBodySize || rootConfig.Template.Upload {
+			templateURL, err := uploadTemplate(ctx, templateBody)
+			if err != nil {
+				return changeSet{}, err
+			}
+			summaryInput.TemplateURL = aws.String(templateURL)
+			createInput.TemplateURL = aws.String(templateURL)
+		} else {
+			summaryInput.TemplateBody = aws.String(string(templateBody))
+			createInput.TemplateBody = aws.String(string(templateBody))
+		}
+	}
+
+	summary, err := cfnClient.GetTemplateSummary(ctx, &summaryInput)
+	if err != nil {
+		return changeSet{}, fmt.Errorf("reading template summary: %w", err)
+	}
+
+	previousParameters := lo.SliceToMap(stack.Parameters, func(p types.Parameter) (string, string) {
+		return *p.ParameterKey, aws.ToString(p.ParameterValue)
+	})
+	var (
+		parameters []types.Parameter
+		missing    []string
+	)
+	for _, declared := range summary.Parameters {
+		key := *declared.ParameterKey
+		if value, ok := input.Parameters[key]; ok {
+			parameters = append(parameters, types.Parameter{
+				ParameterKey:   aws.String(key),
+				ParameterValue: aws.String(value),
+			})
+		} else if _, ok := previousParameters[key]; ok && changeSetType == types.ChangeSetTypeUpdate {
+			parameters = append(parameters, types.Parameter{
+				ParameterKey:     aws.String(key),
+				UsePreviousValue: aws.Bool(true),
+			})
+		} else if declared.DefaultValue == nil {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return changeSet{}, fmt.Errorf("stack %s requires values for parameters: %s", input.StackName, strings.Join(missing, ", "))
+	}
+
+	createInput.StackName = aws.String(input.StackName)
+	createInput.ChangeSetName = aws.String("hfc-" + strconv.FormatInt(time.Now().Unix(), 10))
+	createInput.ChangeSetType = changeSetType
+	createInput.Parameters = parameters
+	createInput.Capabilities = lo.Map(rootConfig.Template.Capabilities, func(c string, _ int) types.Capability {
+		return types.Capability(c)
+	})
+
+	stackConfig, _ := rootConfig.FindStack(input.StackName)
+	settings := rootConfig.StackSettings(stackConfig)
+	createInput.RoleARN = lo.EmptyableToPtr(settings.RoleARN)
+	createInput.NotificationARNs = settings.NotificationARNs
+	for _, key := range slices.Sorted(maps.Keys(settings.Tags)) {
+		createInput.Tags = append(createInput.Tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(settings.Tags[key]),
+		})
+	}
+
+	output, err := cfnClient.CreateChangeSet(ctx, &createInput)
+	if err != nil {
+		return changeSet{}, fmt.Errorf("creating change set: %w", err)
+	}
+
+	cs := changeSet{
+		ID:                 *output.Id,
+		StackName:          input.StackName,
+		Type:               changeSetType,
+		PreviousParameters: previousParameters,
+	}
+
+	waiter := cloudformation.NewChangeSetCreateCompleteWaiter(cfnClient)
+	waitErr := waiter.Wait(ctx, &cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(cs.ID),
+	}, changeSetCreateTimeout)
+	if waitErr == nil {
+		return cs, nil
+	}
+
+	description, err := cfnClient.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(cs.ID),
+	})
+	if err != nil {
+		return changeSet{}, fmt.Errorf("waiting for change set: %w", waitErr)
+	}
+	reason := aws.ToString(description.StatusReason)
+	if description.Status == types.ChangeSetStatusFailed && isEmptyChangeSetReason(reason) {
+		cs.Empty = true
+		return cs, nil
+	}
+	return changeSet{}, fmt.Errorf("change set for stack %s failed: %s", cs.StackName, reason)
+}
+
+// isEmptyChangeSetReason returns true if the status reason for a failed change
+// set indicates that it failed only because it contained no changes. These are
+// the same messages that the AWS CLI checks for.
+func isEmptyChangeSetReason(reason string) bool {
+	return strings.Contains(reason, "The submitted information didn't contain changes") ||
+		strings.Contains(reason, "No updates are to be performed")
+}
+
+// executeChangeSet executes a non-empty change set, and streams stack events to
+// the log until the stack finishes updating.
+func executeChangeSet(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet) error {
+	events, err := newStackEventStream(ctx, cfnClient, cs.StackName)
+	if err != nil {
+		return fmt.Errorf("reading stack events: %w", err)
+	}
+
+	_, err = cfnClient.ExecuteChangeSet(ctx, &cloudformation.ExecuteChangeSetInput{
+		ChangeSetName: aws.String(cs.ID),
+	})
+	if err != nil {
+		return fmt.Errorf("executing change set: %w", err)
+	}
+
+	return waitForStack(ctx, cfnClient, events)
+}
+
+// deleteChangeSet deletes a change set that will not be executed.
+func deleteChangeSet(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet) error {
+	_, err := cfnClient.DeleteChangeSet(ctx, &cloudformation.DeleteChangeSetInput{
+		ChangeSetName: aws.String(cs.ID),
+	})
+	return err
+}
+
+// discardChangeSet deletes a change set that will not be executed. If the change
+// set would have created its stack, discardChangeSet also deletes the stack,
+// which CloudFormation created in REVIEW_IN_PROGRESS status to hold the change
+// set and which has no resources.
+func discardChangeSet(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet) error {
+	if err := deleteChangeSet(ctx, cfnClient, cs); err != nil {
+		return err
+	}
+	if cs.Type != types.ChangeSetTypeCreate {
+		return nil
+	}
+	_, err := cfnClient.DeleteStack(ctx, &cloudformation.DeleteStackInput{
+		StackName: aws.String(cs.StackName),
+	})
+	return err
+}
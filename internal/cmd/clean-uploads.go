@@ -1,134 +1,438 @@
 package cmd
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/ahamlinman/hfc/internal/config"
 )
 
 var cleanUploadsCmd = &cobra.Command{
 	Use:   "clean-uploads",
-	Short: "Remove uploaded Lambda packages not used by any configured stack",
-	Long: `Remove uploaded Lambda packages not used by any configured stack
+	Short: "Remove uploaded Lambda packages not used by any stack",
+	Long: `Remove uploaded Lambda packages not used by any stack
 
 The clean-uploads command deletes S3 objects that start with the prefix in the
-hfc upload configuration but are not in use by any configured stack.
+hfc upload configuration but are not in use by any stack. This includes every
+CloudFormation stack in the current account and region with Lambda package
+parameters that point into the upload bucket and prefix, whether or not the
+stack is configured for hfc.
 
 If the S3 bucket for hfc uploads is shared with other projects, and no prefix is
 defined in the hfc upload configuration, clean-uploads may delete unrelated
 objects from the bucket.
 
-The command prints the keys of objects to be deleted and requests confirmation
-before proceeding.
+Templates that hfc uploaded to the bucket are kept while any of those stacks,
+or any configured stack, is still deployed with the same template. So are the
+nested templates and other local artifacts that those templates refer to.
+
+Unused Lambda packages can be retained according to the retention rules in the
+hfc upload configuration, which can keep a number of the most recent packages,
+packages newer than a given age, and packages in any stack's local deployment
+history. Unused templates and artifacts are always deleted.
+
+The command prints the keys of objects to be kept and deleted, with the reason
+for each, and requests confirmation before proceeding. With --dry-run, it only
+prints this plan, and with --yes, it proceeds without confirmation. However,
+--yes is not allowed when no upload prefix is configured.
 `,
 	PreRun: initializePreRun,
 	Run:    runCleanUploads,
 }
 
+var (
+	cleanUploadsDryRun bool
+	cleanUploadsYes    bool
+	cleanUploadsOutput string
+)
+
 func init() {
+	cleanUploadsCmd.Flags().BoolVar(&cleanUploadsDryRun, "dry-run", false, "print the objects to keep and delete, without deleting anything")
+	cleanUploadsCmd.Flags().BoolVarP(&cleanUploadsYes, "yes", "y", false, "delete objects without confirmation")
+	cleanUploadsCmd.Flags().StringVarP(&cleanUploadsOutput, "output", "o", "text", "format of the plan (text or json)")
 	rootCmd.AddCommand(cleanUploadsCmd)
 }
 
 func runCleanUploads(cmd *cobra.Command, args []string) {
+	if cleanUploadsOutput != "text" && cleanUploadsOutput != "json" {
+		log.Fatalf("unsupported output format %q", cleanUploadsOutput)
+	}
+	if cleanUploadsYes && !cleanUploadsDryRun && rootConfig.Upload.Prefix == "" {
+		log.Fatal("refusing to delete objects without confirmation when no upload prefix is configured")
+	}
+
 	cfnClient := cloudformation.NewFromConfig(awsConfig)
 	s3Client := s3.NewFromConfig(awsConfig)
 	group, ctx := errgroup.WithContext(context.Background())
-	group.SetLimit(5) // TODO: This is arbitrary, is there a specific limit that makes sense?
+	group.SetLimit(maxConcurrentRequests)
 
-	var bucketS3Keys []string
+	var objects []types.Object
 	group.Go(func() (err error) {
-		bucketS3Keys, err = getUploadedS3Keys(ctx, s3Client)
+		objects, err = getUploadedS3Objects(ctx, s3Client)
 		return
 	})
 
-	stackS3Keys := make([]string, len(rootConfig.Stacks))
-	for i, stack := range rootConfig.Stacks {
-		group.Go(func() (err error) {
-			stackS3Keys[i], err = getStackS3Key(ctx, cfnClient, stack.Name)
-			return
-		})
-	}
+	var inUse map[string][]string
+	group.Go(func() (err error) {
+		inUse, err = getAllStackS3Keys(ctx, cfnClient)
+		return
+	})
 
 	if err := group.Wait(); err != nil {
 		log.Fatal(err)
 	}
 
-	bucketS3Keys = lo.Uniq(bucketS3Keys)
-	stackS3Keys = lo.Uniq(stackS3Keys)
+	packagedKeys := lo.FilterMap(objects, func(o types.Object, _ int) (string, bool) {
+		return *o.Key, isTemplateKey(*o.Key) || isArtifactKey(*o.Key)
+	})
+	if len(packagedKeys) > 0 {
+		stackNames := lo.Map(rootConfig.Stacks, func(s config.StackConfig, _ int) string { return s.Name })
+		for _, names := range inUse {
+			stackNames = append(stackNames, names...)
+		}
+		templateKeys, err := getStackTemplateKeys(context.Background(), cfnClient, s3Client, lo.Uniq(stackNames), packagedKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for key, names := range templateKeys {
+			inUse[key] = append(inUse[key], names...)
+		}
+	}
+
+	var previouslyDeployed map[string][]string
+	if rootConfig.Upload.Retention.KeepPreviousDeployments {
+		var err error
+		previouslyDeployed, err = getPreviouslyDeployedS3Keys()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	plan := planCleanup(objects, inUse, previouslyDeployed, rootConfig.Upload.Retention, time.Now())
+	keepObjects := lo.Filter(plan, func(o cleanupObject, _ int) bool { return !o.Delete })
+	deleteObjects := lo.Filter(plan, func(o cleanupObject, _ int) bool { return o.Delete })
 
-	keepKeys := lo.Intersect(bucketS3Keys, stackS3Keys)
-	deleteKeys, _ := lo.Difference(bucketS3Keys, stackS3Keys)
+	if cleanUploadsOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(cleanupPlan{
+			Bucket:  rootConfig.Upload.Bucket,
+			Prefix:  rootConfig.Upload.Prefix,
+			Objects: plan,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if len(deleteKeys) == 0 {
+	if len(deleteObjects) == 0 {
 		log.Print("Bucket is clean enough, no objects to delete.")
 		return
 	}
 
-	if len(keepKeys) > 0 {
-		log.Print("Will keep the following in-use objects:\n\n")
-		for _, key := range keepKeys {
-			fmt.Fprintf(log.Writer(), "\t%s\n", key)
+	if cleanUploadsOutput == "text" {
+		if len(keepObjects) > 0 {
+			log.Print("Will keep the following objects:\n\n")
+			printCleanupObjects(keepObjects)
+		}
+		log.Print("Will delete the following unused objects:\n\n")
+		printCleanupObjects(deleteObjects)
+	}
+
+	if cleanUploadsDryRun {
+		return
+	}
+
+	if !cleanUploadsYes {
+		fmt.Fprint(log.Writer(), log.Prefix()+"Press Enter to continue...")
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+			log.Fatalf("no confirmation received: %v", err)
+		}
+	}
+
+	deleteKeys := lo.Map(deleteObjects, func(o cleanupObject, _ int) string { return o.Key })
+	if errs := deleteS3Objects(context.Background(), s3Client, deleteKeys); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}
+
+	log.Print("Deleted all unused objects.")
+}
+
+// getAllStackS3Keys returns the S3 keys of all Lambda packages in the upload
+// bucket and prefix that are in use by any stack in the current account and
+// region, whether or not the stack is configured, mapped to the names of the
+// stacks that use them.
+func getAllStackS3Keys(ctx context.Context, cfnClient *cloudformation.Client) (map[string][]string, error) {
+	keys := make(map[string][]string)
+	paginator := cloudformation.NewDescribeStacksPaginator(cfnClient, &cloudformation.DescribeStacksInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stack := range page.Stacks {
+			for _, p := range stack.Parameters {
+				name, ok := strings.CutSuffix(*p.ParameterKey, "CodeS3Bucket")
+				if !ok || aws.ToString(p.ParameterValue) != rootConfig.Upload.Bucket {
+					continue
+				}
+				key, ok := getStackParameter(stack, name+"CodeS3Key")
+				if ok && strings.HasPrefix(key, rootConfig.Upload.Prefix) {
+					keys[key] = append(keys[key], *stack.StackName)
+				}
+			}
 		}
-		fmt.Fprint(log.Writer(), "\n")
 	}
+	return keys, nil
+}
 
-	log.Print("Will delete the following unused objects:\n\n")
-	for _, key := range deleteKeys {
-		fmt.Fprintf(log.Writer(), "\t%s\n", key)
+// getStackTemplateKeys returns the S3 keys of the uploaded templates and
+// artifacts that the current templates of the named stacks use, mapped to the
+// names of the stacks that use them. Stacks that do not exist are ignored.
+//
+// A stack uses an uploaded template if its current template has the same hash,
+// and uses any of the candidate keys that appear in that template or in any
+// uploaded nested template that it uses.
+func getStackTemplateKeys(
+	ctx context.Context,
+	cfnClient *cloudformation.Client,
+	s3Client *s3.Client,
+	stackNames, candidateKeys []string,
+) (map[string][]string, error) {
+	var group errgroup.Group
+	group.SetLimit(maxConcurrentRequests)
+	bodies := make([]string, len(stackNames))
+	for i, name := range stackNames {
+		group.Go(func() error {
+			output, err := cfnClient.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+				StackName:     aws.String(name),
+				TemplateStage: cfntypes.TemplateStageOriginal,
+			})
+			if isStackNotExistError(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			bodies[i] = aws.ToString(output.TemplateBody)
+			return nil
+		})
 	}
-	fmt.Fprint(log.Writer(), "\n"+log.Prefix()+"Press Enter to continue...")
-	fmt.Scanln()
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]string)
+	nestedBodies := make(map[string]string)
+	for i, name := range stackNames {
+		if bodies[i] == "" {
+			continue
+		}
+
+		used := map[string]bool{templateKey([]byte(bodies[i])): true}
+		pending := []string{bodies[i]}
+		for len(pending) > 0 {
+			body := pending[0]
+			pending = pending[1:]
+			for _, key := range candidateKeys {
+				if used[key] || !strings.Contains(body, key) {
+					continue
+				}
+				used[key] = true
+				if !isTemplateKey(key) {
+					continue
+				}
 
-	deleteIdentifiers := make([]types.ObjectIdentifier, len(deleteKeys))
-	for i, key := range deleteKeys {
-		deleteIdentifiers[i] = types.ObjectIdentifier{Key: &key}
+				nested, ok := nestedBodies[key]
+				if !ok {
+					var err error
+					nested, err = getS3ObjectString(ctx, s3Client, key)
+					if err != nil {
+						return nil, err
+					}
+					nestedBodies[key] = nested
+				}
+				pending = append(pending, nested)
+			}
+		}
+
+		for key := range used {
+			keys[key] = append(keys[key], name)
+		}
 	}
-	output, err := s3Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+	for _, names := range keys {
+		slices.Sort(names)
+	}
+	return keys, nil
+}
+
+// getS3ObjectString returns the content of an object in the upload bucket.
+func getS3ObjectString(ctx context.Context, s3Client *s3.Client, key string) (string, error) {
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(rootConfig.Upload.Bucket),
-		Delete: &types.Delete{
-			Objects: deleteIdentifiers,
-			Quiet:   aws.Bool(true),
-		},
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
+	defer output.Body.Close()
+	body, err := io.ReadAll(output.Body)
+	return string(body), err
+}
+
+// cleanupPlan is the machine-readable form of the clean-uploads plan.
+type cleanupPlan struct {
+	Bucket  string          `json:"bucket"`
+	Prefix  string          `json:"prefix"`
+	Objects []cleanupObject `json:"objects"`
+}
+
+// cleanupObject is an uploaded object that clean-uploads has decided to keep
+// or delete, along with the reason for that decision.
+type cleanupObject struct {
+	Key          string    `json:"key"`
+	LastModified time.Time `json:"last_modified"`
+	Delete       bool      `json:"delete"`
+	Reason       string    `json:"reason"`
+}
+
+// planCleanup decides which uploaded objects to keep or delete, given the keys
+// in use by stacks and previously deployed to stacks (each mapped to stack
+// names) along with the retention configuration. The plan is ordered from the
+// newest to the oldest object.
+//
+// The retention rules only apply to Lambda packages, which are the targets for
+// rollbacks. Other uploads are kept only while they are in use.
+func planCleanup(
+	objects []types.Object,
+	inUse, previouslyDeployed map[string][]string,
+	retention config.RetentionConfig,
+	now time.Time,
+) []cleanupObject {
+	objects = slices.Clone(objects)
+	slices.SortFunc(objects, func(a, b types.Object) int {
+		return cmp.Or(b.LastModified.Compare(*a.LastModified), cmp.Compare(*a.Key, *b.Key))
+	})
 
-	if len(output.Errors) > 0 {
-		for _, e := range output.Errors {
-			log.Printf("failed to delete %s: %s", *e.Key, *e.Message)
+	var packages int
+	plan := make([]cleanupObject, len(objects))
+	for i, object := range objects {
+		o := cleanupObject{Key: *object.Key, LastModified: *object.LastModified}
+		isPackage := isLambdaPackageKey(o.Key)
+		if isPackage {
+			packages++
 		}
-		os.Exit(1)
+
+		switch {
+		case len(inUse[o.Key]) > 0:
+			o.Reason = "in use by " + strings.Join(inUse[o.Key], ", ")
+		case !isPackage:
+			o.Delete = true
+			o.Reason = "not in use by any stack"
+		case packages <= retention.KeepLatest:
+			o.Reason = fmt.Sprintf("one of the %d most recent uploads", retention.KeepLatest)
+		case retention.KeepNewerThan > 0 && now.Sub(o.LastModified) < retention.KeepNewerThan:
+			o.Reason = fmt.Sprintf("uploaded less than %v ago", retention.KeepNewerThan)
+		case len(previouslyDeployed[o.Key]) > 0:
+			o.Reason = "previously deployed to " + strings.Join(previouslyDeployed[o.Key], ", ")
+		default:
+			o.Delete = true
+			o.Reason = "not in use by any stack"
+		}
+		plan[i] = o
 	}
+	return plan
+}
 
-	log.Print("Deleted all unused objects.")
+func printCleanupObjects(objects []cleanupObject) {
+	for _, o := range objects {
+		fmt.Fprintf(log.Writer(), "\t%s  (%s)\n", o.Key, o.Reason)
+	}
+	fmt.Fprint(log.Writer(), "\n")
 }
 
-// getUploadedS3Keys returns the S3 keys of all Lambda packages currently in the
-// deployment bucket, in the standard order returned by S3.
-//
-// The current implementation is limited to returning 1,000 keys.
-func getUploadedS3Keys(ctx context.Context, s3Client *s3.Client) ([]string, error) {
-	output, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+// getUploadedS3Objects returns the S3 objects for all Lambda packages currently
+// in the deployment bucket, in the standard order returned by S3.
+func getUploadedS3Objects(ctx context.Context, s3Client *s3.Client) ([]types.Object, error) {
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(rootConfig.Upload.Bucket),
 		Prefix: aws.String(rootConfig.Upload.Prefix),
 	})
-	if err != nil {
-		return nil, err
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
 	}
+	return objects, nil
+}
+
+// maxDeleteObjects is the maximum number of keys that S3 accepts in a single
+// DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// deleteS3Objects deletes objects from the deployment bucket in concurrent
+// batches, and returns an error for each key that it failed to delete.
+func deleteS3Objects(ctx context.Context, s3Client *s3.Client, keys []string) []error {
+	var (
+		group errgroup.Group
+		mu    sync.Mutex
+		errs  []error
+	)
+	group.SetLimit(maxConcurrentRequests)
+	for batch := range slices.Chunk(keys, maxDeleteObjects) {
+		group.Go(func() error {
+			identifiers := make([]types.ObjectIdentifier, len(batch))
+			for i, key := range batch {
+				identifiers[i] = types.ObjectIdentifier{Key: aws.String(key)}
+			}
+			output, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(rootConfig.Upload.Bucket),
+				Delete: &types.Delete{
+					Objects: identifiers,
+					Quiet:   aws.Bool(true),
+				},
+			})
 
-	keys := make([]string, len(output.Contents))
-	for i, object := range output.Contents {
-		keys[i] = *object.Key
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, key := range batch {
+					errs = append(errs, fmt.Errorf("failed to delete %s: %w", key, err))
+				}
+				return nil
+			}
+			for _, e := range output.Errors {
+				errs = append(errs, fmt.Errorf("failed to delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message)))
+			}
+			return nil
+		})
 	}
-	return keys, nil
+	group.Wait()
+	return errs
 }
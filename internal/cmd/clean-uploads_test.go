@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+func TestPlanCleanup(t *testing.T) {
+	rootConfig = config.Config{Upload: config.UploadConfig{Prefix: "hfc/"}}
+	t.Cleanup(func() { rootConfig = config.Config{} })
+
+	now := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	object := func(key string, age time.Duration) types.Object {
+		return types.Object{Key: aws.String(key), LastModified: aws.Time(now.Add(-age))}
+	}
+	// The objects are deliberately out of order, and two packages were uploaded
+	// at the same time.
+	objects := []types.Object{
+		object("hfc/1000.zip", 72*time.Hour),
+		object("hfc/template-new.yaml", 1*time.Hour),
+		object("hfc/3001.zip", 5*time.Hour),
+		object("hfc/4000.zip", 3*time.Hour),
+		object("hfc/artifact-new.zip", 2*time.Hour),
+		object("hfc/3000.zip", 5*time.Hour),
+		object("hfc/template-old.yaml", 96*time.Hour),
+		object("hfc/2000.zip", 48*time.Hour),
+		object("hfc/3500.zip", 4*time.Hour),
+	}
+	inUse := map[string][]string{
+		"hfc/4000.zip":          {"Staging"},
+		"hfc/template-old.yaml": {"Production"},
+	}
+	previouslyDeployed := map[string][]string{
+		"hfc/4000.zip": {"Staging"},
+		"hfc/2000.zip": {"Production", "Staging"},
+	}
+
+	const (
+		deleted = "not in use by any stack"
+		latest  = "one of the 2 most recent uploads"
+		newer   = "uploaded less than 6h0m0s ago"
+		history = "previously deployed to Production, Staging"
+	)
+	keep := func(key, reason string) cleanupObject { return cleanupObject{Key: key, Reason: reason} }
+	remove := func(key string) cleanupObject { return cleanupObject{Key: key, Delete: true, Reason: deleted} }
+
+	testCases := []struct {
+		Description string
+		Retention   config.RetentionConfig
+		Want        []cleanupObject
+	}{
+		{
+			Description: "no retention",
+			Want: []cleanupObject{
+				remove("hfc/template-new.yaml"),
+				remove("hfc/artifact-new.zip"),
+				keep("hfc/4000.zip", "in use by Staging"),
+				remove("hfc/3500.zip"),
+				remove("hfc/3000.zip"),
+				remove("hfc/3001.zip"),
+				remove("hfc/2000.zip"),
+				remove("hfc/1000.zip"),
+				keep("hfc/template-old.yaml", "in use by Production"),
+			},
+		},
+		{
+			// Templates and artifacts don't count toward the latest uploads, but
+			// packages in use do.
+			Description: "keep latest",
+			Retention:   config.RetentionConfig{KeepLatest: 2},
+			Want: []cleanupObject{
+				remove("hfc/template-new.yaml"),
+				remove("hfc/artifact-new.zip"),
+				keep("hfc/4000.zip", "in use by Staging"),
+				keep("hfc/3500.zip", latest),
+				remove("hfc/3000.zip"),
+				remove("hfc/3001.zip"),
+				remove("hfc/2000.zip"),
+				remove("hfc/1000.zip"),
+				keep("hfc/template-old.yaml", "in use by Production"),
+			},
+		},
+		{
+			Description: "keep newer than",
+			Retention:   config.RetentionConfig{KeepNewerThan: 6 * time.Hour},
+			Want: []cleanupObject{
+				remove("hfc/template-new.yaml"),
+				remove("hfc/artifact-new.zip"),
+				keep("hfc/4000.zip", "in use by Staging"),
+				keep("hfc/3500.zip", newer),
+				keep("hfc/3000.zip", newer),
+				keep("hfc/3001.zip", newer),
+				remove("hfc/2000.zip"),
+				remove("hfc/1000.zip"),
+				keep("hfc/template-old.yaml", "in use by Production"),
+			},
+		},
+		{
+			Description: "keep previous deployments",
+			Retention:   config.RetentionConfig{KeepPreviousDeployments: true},
+			Want: []cleanupObject{
+				remove("hfc/template-new.yaml"),
+				remove("hfc/artifact-new.zip"),
+				keep("hfc/4000.zip", "in use by Staging"),
+				remove("hfc/3500.zip"),
+				remove("hfc/3000.zip"),
+				remove("hfc/3001.zip"),
+				keep("hfc/2000.zip", history),
+				remove("hfc/1000.zip"),
+				keep("hfc/template-old.yaml", "in use by Production"),
+			},
+		},
+		{
+			Description: "all rules",
+			Retention: config.RetentionConfig{
+				KeepLatest:              2,
+				KeepNewerThan:           6 * time.Hour,
+				KeepPreviousDeployments: true,
+			},
+			Want: []cleanupObject{
+				remove("hfc/template-new.yaml"),
+				remove("hfc/artifact-new.zip"),
+				keep("hfc/4000.zip", "in use by Staging"),
+				keep("hfc/3500.zip", latest),
+				keep("hfc/3000.zip", newer),
+				keep("hfc/3001.zip", newer),
+				keep("hfc/2000.zip", history),
+				remove("hfc/1000.zip"),
+				keep("hfc/template-old.yaml", "in use by Production"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			var deployed map[string][]string
+			if tc.Retention.KeepPreviousDeployments {
+				deployed = previouslyDeployed
+			}
+			got := planCleanup(objects, inUse, deployed, tc.Retention, now)
+			if diff := cmp.Diff(tc.Want, got, cmpopts.IgnoreFields(cleanupObject{}, "LastModified")); diff != "" {
+				t.Errorf("unexpected plan (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
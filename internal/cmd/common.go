@@ -2,26 +2,119 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/ahamlinman/hfc/internal/shelley"
 )
 
-// getStackS3Key returns the full S3 key (including prefix) for the Lambda
-// package currently in use by the named stack.
-func getStackS3Key(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (string, error) {
-	stack, err := cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+// maxConcurrentRequests limits the number of concurrent AWS API requests that
+// any one command makes.
+//
+// TODO: This is arbitrary, is there a specific limit that makes sense?
+const maxConcurrentRequests = 5
+
+// errStackNotExist is wrapped by errors from describeStack when the named stack
+// has not been created.
+var errStackNotExist = errors.New("stack does not exist")
+
+// describeStack returns the current description of the named stack.
+func describeStack(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (types.Stack, error) {
+	output, err := cfnClient.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackName),
 	})
+
+	if isStackNotExistError(err) {
+		return types.Stack{}, fmt.Errorf("%w: %s", errStackNotExist, stackName)
+	}
 	if err != nil {
-		return "", err
+		return types.Stack{}, err
 	}
 
-	for _, p := range stack.Stacks[0].Parameters {
-		if *p.ParameterKey == "CodeS3Key" {
-			return *p.ParameterValue, nil
+	return output.Stacks[0], nil
+}
+
+// isStackNotExistError returns true if err is the error that the CloudFormation
+// API returns for operations on a stack that does not exist.
+func isStackNotExistError(err error) bool {
+	// CloudFormation doesn't have a distinct error code for missing stacks, so
+	// this is the same message check that the AWS CLI uses.
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.ErrorMessage(), "does not exist")
+}
+
+// getStackS3Keys returns the full S3 keys (including prefix) for the Lambda
+// packages currently in use by the named stack, indexed by the names of their
+// stack parameters.
+func getStackS3Keys(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (map[string]string, error) {
+	stack, err := describeStack(ctx, cfnClient, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string)
+	for _, p := range stack.Parameters {
+		if strings.HasSuffix(*p.ParameterKey, "CodeS3Key") {
+			keys[*p.ParameterKey] = aws.ToString(p.ParameterValue)
 		}
 	}
-	return "", fmt.Errorf("stack %s deployed without CodeS3Key parameter", stackName)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("stack %s deployed without any CodeS3Key parameter", stackName)
+	}
+	return keys, nil
+}
+
+// getStackParameter returns the current value of a stack's parameter, or
+// ok == false if the stack has no parameter with that key.
+func getStackParameter(stack types.Stack, key string) (value string, ok bool) {
+	for _, p := range stack.Parameters {
+		if *p.ParameterKey == key {
+			return aws.ToString(p.ParameterValue), true
+		}
+	}
+	return "", false
+}
+
+// checkPackageExists returns a non-nil error if the S3 object for a Lambda
+// package does not exist.
+func checkPackageExists(ctx context.Context, bucket, key string) error {
+	exists, err := objectExists(ctx, bucket, key)
+	if err == nil && !exists {
+		return fmt.Errorf("package s3://%s/%s no longer exists", bucket, key)
+	}
+	return err
+}
+
+// objectExists returns true if an S3 object, such as a Lambda package, exists.
+func objectExists(ctx context.Context, bucket, key string) (bool, error) {
+	s3Client := s3.NewFromConfig(awsConfig)
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// commandOutput runs a command and returns its standard output, without any
+// leading or trailing whitespace.
+func commandOutput(args ...string) (string, error) {
+	var stdout strings.Builder
+	shell := *shelley.DefaultContext
+	shell.Stdout = &stdout
+	if err := shell.Command(args...).Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
 }
@@ -1,101 +1,352 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"maps"
 	"os"
 	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 
-	"github.com/ahamlinman/hfc/internal/shelley"
+	"github.com/ahamlinman/hfc/internal/cfn"
+	"github.com/ahamlinman/hfc/internal/config"
 )
 
 var deployCmd = &cobra.Command{
-	Use:               "deploy [flags] stack [parameters]",
-	Short:             "Deploy the CloudFormation stack with the latest upload",
-	Args:              cobra.MinimumNArgs(1),
+	Use:   "deploy [flags] stack [parameters]",
+	Short: "Deploy the CloudFormation stack with the latest upload",
+	Long: `Deploy the CloudFormation stack with the latest upload
+
+Stack parameters in the configuration may refer to the outputs of other stacks,
+which are read at deploy time. With --all, deploy deploys every configured stack
+in order, so that stacks are deployed after the stacks they refer to.
+`,
+	Args:              validateDeployArgs,
 	ValidArgsFunction: completeStackNames,
 	PreRun:            initializePreRun,
 	Run:               runDeploy,
 }
 
+var (
+	deployPreview       bool
+	deployKeepChangeSet bool
+	deployYes           bool
+	deployIgnoreDrift   bool
+	deployAll           bool
+)
+
 func init() {
+	deployCmd.Flags().BoolVar(&deployPreview, "preview", false, "print the changes that would be deployed, without deploying them")
+	deployCmd.Flags().BoolVar(&deployKeepChangeSet, "keep", false, "with --preview, keep the change set instead of deleting it")
+	deployCmd.Flags().BoolVarP(&deployYes, "yes", "y", false, "deploy protected stacks without confirmation")
+	deployCmd.Flags().BoolVar(&deployIgnoreDrift, "ignore-drift", false, "skip the drift check for stacks that enable it")
+	deployCmd.Flags().BoolVar(&deployAll, "all", false, "deploy all configured stacks in dependency order")
 	rootCmd.AddCommand(deployCmd)
 }
 
+func validateDeployArgs(cmd *cobra.Command, args []string) error {
+	if deployAll {
+		return cobra.NoArgs(cmd, args)
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
 func runDeploy(cmd *cobra.Command, args []string) {
+	if deployAll {
+		runDeployAll()
+		return
+	}
+
 	stackName := args[0]
 	stack, ok := rootConfig.FindStack(stackName)
 	if !ok {
 		log.Fatalf("stack %s is not configured", stackName)
 	}
 
-	lambdaParameters, err := getLambdaPackageParameters()
+	lambdaParameters, err := getLambdaPackageParameters(stack)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	allParameters, err := getDeployParameters(stack, lambdaParameters, args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	cliParameters := slices.Clone(args[1:])
-	allParameters := lo.Flatten([][]string{
-		lambdaParameters,
-		cliParameters,
-		lo.MapToSlice(stack.Parameters, func(k, v string) string { return k + "=" + v }),
-	})
-	slices.Sort(allParameters)
-
-	deployArgs := lo.Flatten([][]string{
-		{"aws", "cloudformation", "deploy"},
-		lo.Ternary(
-			rootConfig.AWS.Region == "", nil,
-			[]string{"--region", rootConfig.AWS.Region},
-		),
-		{
-			"--template-file", rootConfig.Template.Path,
-			"--stack-name", stackName,
-			"--no-fail-on-empty-changeset",
-		},
-		lo.Ternary(
-			len(rootConfig.Template.Capabilities) == 0, nil,
-			lo.Flatten([][]string{{"--capabilities"}, rootConfig.Template.Capabilities}),
-		),
-		{"--parameter-overrides"},
-		allParameters,
-	})
-	shelley.ExitIfError(shelley.Command(deployArgs...).Run())
+	deployStack(stack, changeSetInput{Parameters: allParameters})
+}
+
+// runDeployAll deploys every configured stack with the latest upload, in an
+// order that satisfies the references between their parameters.
+func runDeployAll() {
+	stacks, err := rootConfig.DeployOrder()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, stack := range stacks {
+		lambdaParameters, err := getLambdaPackageParameters(stack)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		allParameters, err := getDeployParameters(stack, lambdaParameters, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		deployStack(stack, changeSetInput{Parameters: allParameters})
+	}
+}
 
+// deployStack deploys a stack as described by the change set input, honoring
+// the flags for previews, drift checks, and protected stack confirmation, and
+// exits the process if the deployment fails.
+func deployStack(stack config.StackConfig, input changeSetInput) {
+	ctx := context.Background()
 	cfnClient := cloudformation.NewFromConfig(awsConfig)
-	description, err := cfnClient.DescribeStacks(context.Background(), &cloudformation.DescribeStacksInput{
-		StackName: aws.String(stackName),
-	})
+
+	if stack.DriftCheck && !deployPreview && !deployIgnoreDrift {
+		if err := checkStackDrift(ctx, cfnClient, stack.Name); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("Creating change set for stack %s", stack.Name)
+	input.StackName = stack.Name
+	cs, err := createChangeSet(ctx, cfnClient, input)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if deployPreview {
+		previewChangeSet(ctx, cfnClient, cs, deployKeepChangeSet)
+		return
+	}
+
+	if cs.Empty {
+		log.Printf("No changes to deploy for stack %s", stack.Name)
+		if err := deleteChangeSet(ctx, cfnClient, cs); err != nil {
+			log.Printf("unable to delete empty change set: %v", err)
+		}
+	} else {
+		if stack.Protected && !deployYes {
+			if err := confirmProtectedDeploy(ctx, cfnClient, cs); err != nil {
+				if err := discardChangeSet(ctx, cfnClient, cs); err != nil {
+					log.Printf("unable to delete change set: %v", err)
+				}
+				log.Fatal(err)
+			}
+		}
+		log.Printf("Executing change set for stack %s", stack.Name)
+		if err := executeChangeSet(ctx, cfnClient, cs); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Successfully deployed stack %s", stack.Name)
+	}
+
+	if err := applyStackSettings(ctx, cfnClient, stack); err != nil {
+		log.Fatal(err)
+	}
+
+	description, err := describeStack(ctx, cfnClient, stack.Name)
 	if err != nil {
 		log.Print("unable to read stack info, will skip printing output")
 		return
 	}
 
-	for _, output := range description.Stacks[0].Outputs {
-		log.Printf("%s (%s):\n\t%s", *output.Description, *output.OutputKey, *output.OutputValue)
+	if !cs.Empty {
+		if err := appendDeployHistory(stack.Name, newDeployRecord(description)); err != nil {
+			log.Printf("unable to record deployment history: %v", err)
+		}
+	}
+
+	if stack.WriteOutputs {
+		if err := saveStackOutputs(description); err != nil {
+			log.Printf("unable to write stack outputs: %v", err)
+		}
+	}
+
+	for _, output := range description.Outputs {
+		key, value := aws.ToString(output.OutputKey), aws.ToString(output.OutputValue)
+		if description := aws.ToString(output.Description); description != "" {
+			log.Printf("%s (%s):\n\t%s", description, key, value)
+		} else {
+			log.Printf("%s:\n\t%s", key, value)
+		}
 	}
 }
 
-func getLambdaPackageParameters() ([]string, error) {
-	latestPackageRaw, err := os.ReadFile(rootState.LatestLambdaPackagePath())
+// applyStackSettings applies the termination protection and stack policy
+// settings for a deployed stack, which CloudFormation does not apply through
+// change sets.
+func applyStackSettings(ctx context.Context, cfnClient *cloudformation.Client, stack config.StackConfig) error {
+	settings := rootConfig.StackSettings(stack)
+
+	if settings.TerminationProtection != nil {
+		_, err := cfnClient.UpdateTerminationProtection(ctx, &cloudformation.UpdateTerminationProtectionInput{
+			StackName:                   aws.String(stack.Name),
+			EnableTerminationProtection: settings.TerminationProtection,
+		})
+		if err != nil {
+			return fmt.Errorf("updating termination protection for stack %s: %w", stack.Name, err)
+		}
+	}
+
+	if settings.StackPolicy != "" {
+		policy, err := os.ReadFile(settings.StackPolicy)
+		if err != nil {
+			return fmt.Errorf("reading stack policy: %w", err)
+		}
+		_, err = cfnClient.SetStackPolicy(ctx, &cloudformation.SetStackPolicyInput{
+			StackName:       aws.String(stack.Name),
+			StackPolicyBody: aws.String(string(policy)),
+		})
+		if err != nil {
+			return fmt.Errorf("setting stack policy for stack %s: %w", stack.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// confirmProtectedDeploy prints the changes in a change set for a protected
+// stack, and returns a non-nil error unless the user confirms the deployment by
+// typing the stack's name.
+func confirmProtectedDeploy(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet) error {
+	if err := printChangeSet(ctx, cfnClient, cs); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(log.Writer(), "\n%sStack %s is protected. Type its name to deploy: ", log.Prefix(), cs.StackName)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if strings.TrimSpace(answer) != cs.StackName {
+		return fmt.Errorf("deployment of protected stack %s not confirmed (use --yes to skip confirmation)", cs.StackName)
+	}
+	return nil
+}
+
+// getDeployParameters returns the full set of parameters to deploy a stack
+// with, given the parameters for its Lambda package and parameters from the
+// command line in Key=Value form.
+//
+// Command line parameters take precedence over parameters in the stack's
+// configuration, which take precedence over the Lambda package parameters.
+//
+// The parameters from the configuration and command line must be declared by
+// the configured template, all parameters must meet the constraints in the
+// template's declarations, and every declared parameter without a default must
+// have a value, either from these parameters or from the stack's previous
+// deployment.
+func getDeployParameters(stack config.StackConfig, lambdaParameters map[string]string, args []string) (map[string]string, error) {
+	cliParameters, err := parseParameterArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	templateBody, err := readTemplateBody(stack.Name)
+	if err != nil {
+		return nil, err
+	}
+	template, err := cfn.ParseTemplate(templateBody)
+	if err != nil {
+		return nil, err
+	}
+	names := slices.Concat(slices.Collect(maps.Keys(stack.Parameters)), slices.Collect(maps.Keys(cliParameters)))
+	if err := template.CheckDeclared(names); err != nil {
+		return nil, fmt.Errorf("invalid parameters for stack %s:\n%w", stack.Name, err)
+	}
+
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	stackParameters, err := resolveStackParameters(context.Background(), cfnClient, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	allParameters := make(map[string]string)
+	maps.Copy(allParameters, lambdaParameters)
+	maps.Copy(allParameters, stackParameters)
+	maps.Copy(allParameters, cliParameters)
+	if err := template.CheckValues(allParameters); err != nil {
+		return nil, fmt.Errorf("invalid parameters for stack %s:\n%w", stack.Name, err)
+	}
+
+	previousNames, err := getPreviousParameterNames(context.Background(), cfnClient, stack.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := template.CheckRequired(slices.Concat(slices.Collect(maps.Keys(allParameters)), previousNames)); err != nil {
+		return nil, fmt.Errorf("invalid parameters for stack %s:\n%w", stack.Name, err)
+	}
+	return allParameters, nil
+}
+
+// getPreviousParameterNames returns the names of the parameters that a stack
+// was previously deployed with, whose values a new deployment can keep. Stacks
+// that have never been deployed have no previous parameters.
+func getPreviousParameterNames(ctx context.Context, cfnClient *cloudformation.Client, stackName string) ([]string, error) {
+	description, err := describeStack(ctx, cfnClient, stackName)
 	switch {
-	case errors.Is(err, fs.ErrNotExist):
-		return nil, errors.New("must upload a deployment package before deploying")
+	case errors.Is(err, errStackNotExist):
+		return nil, nil
 	case err != nil:
 		return nil, err
+	case description.StackStatus == types.StackStatusReviewInProgress:
+		return nil, nil
+	}
+	return lo.Map(description.Parameters, func(p types.Parameter, _ int) string { return *p.ParameterKey }), nil
+}
+
+// getLambdaPackageParameters returns the parameters to deploy a stack with the
+// latest uploaded Lambda packages.
+func getLambdaPackageParameters(stack config.StackConfig) (map[string]string, error) {
+	arch := rootConfig.StackArch(stack)
+	parameters := map[string]string{"Architecture": string(arch)}
+	for _, fn := range rootConfig.Build.AllFunctions() {
+		latest, err := readLatestLambdaPackage(fn)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("must upload a %s before deploying", describePackage(fn))
+		case err != nil:
+			return nil, err
+		}
+
+		if latest.Arch != arch {
+			return nil, fmt.Errorf(
+				"latest %s was built for %s, but stack %s uses %s (try building for the stack)",
+				describePackage(fn), latest.Arch, stack.Name, arch)
+		}
+
+		parameters[fn.S3BucketParameter()] = rootConfig.Upload.Bucket
+		parameters[fn.S3KeyParameter()] = latest.Key
 	}
+	return parameters, nil
+}
 
-	latestPackage := strings.TrimSpace(string(latestPackageRaw))
-	return []string{
-		"CodeS3Bucket=" + rootConfig.Upload.Bucket,
-		"CodeS3Key=" + latestPackage,
-	}, nil
+// parseParameterArgs parses stack parameters provided on the command line in
+// Key=Value form.
+func parseParameterArgs(args []string) (map[string]string, error) {
+	parameters := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid parameter %q, must be in Key=Value form", arg)
+		}
+		parameters[key] = value
+	}
+	return parameters, nil
 }
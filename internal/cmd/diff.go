@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] stack [parameters]",
+	Short: "Preview the changes that a deployment would make to the stack",
+	Long: `Preview the changes that a deployment would make to the stack
+
+The diff command creates a change set for the stack with the latest upload,
+exactly as the deploy command would, and prints the parameter and resource
+changes it contains. The change set is deleted afterward unless --keep is set.
+`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runDiff,
+}
+
+var diffKeepChangeSet bool
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffKeepChangeSet, "keep", false, "keep the change set instead of deleting it")
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	stackName := args[0]
+	stack, ok := rootConfig.FindStack(stackName)
+	if !ok {
+		log.Fatalf("stack %s is not configured", stackName)
+	}
+
+	lambdaParameters, err := getLambdaPackageParameters(stack)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	parameters, err := getDeployParameters(stack, lambdaParameters, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+
+	log.Printf("Creating change set for stack %s", stackName)
+	cs, err := createChangeSet(ctx, cfnClient, changeSetInput{
+		StackName:  stackName,
+		Parameters: parameters,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	previewChangeSet(ctx, cfnClient, cs, diffKeepChangeSet)
+}
+
+// previewChangeSet prints the changes in a change set, then discards the change
+// set unless keep is set.
+func previewChangeSet(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet, keep bool) {
+	printErr := printChangeSet(ctx, cfnClient, cs)
+
+	if keep && !cs.Empty {
+		log.Printf("Kept change set %s", cs.ID)
+	} else if err := discardChangeSet(ctx, cfnClient, cs); err != nil {
+		log.Printf("unable to delete change set: %v", err)
+	}
+
+	if printErr != nil {
+		log.Fatal(printErr)
+	}
+}
+
+// printChangeSet prints a human-readable summary of the parameter and resource
+// changes in a change set to stdout.
+func printChangeSet(ctx context.Context, cfnClient *cloudformation.Client, cs changeSet) error {
+	if cs.Empty {
+		fmt.Printf("No changes to stack %s.\n", cs.StackName)
+		return nil
+	}
+
+	var (
+		parameters []types.Parameter
+		changes    []types.Change
+		nextToken  *string
+	)
+	for {
+		output, err := cfnClient.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			ChangeSetName: aws.String(cs.ID),
+			NextToken:     nextToken,
+		})
+		if err != nil {
+			return fmt.Errorf("describing change set: %w", err)
+		}
+		parameters = output.Parameters
+		changes = append(changes, output.Changes...)
+		if nextToken = output.NextToken; nextToken == nil {
+			break
+		}
+	}
+
+	tw := newTabWriter(os.Stdout)
+
+	slices.SortFunc(parameters, func(a, b types.Parameter) int {
+		return strings.Compare(*a.ParameterKey, *b.ParameterKey)
+	})
+	var parameterChanged bool
+	for _, p := range parameters {
+		before, existed := cs.PreviousParameters[*p.ParameterKey]
+		after := aws.ToString(p.ParameterValue)
+		if existed && before == after {
+			continue
+		}
+		if !parameterChanged {
+			fmt.Fprintf(tw, "Parameters:\n")
+			parameterChanged = true
+		}
+		tw.WriteColumn("  " + *p.ParameterKey)
+		tw.WriteColumn(lo.Ternary(existed, redactParameter(*p.ParameterKey, before), "(none)"))
+		tw.WriteColumn("->")
+		tw.WriteColumn(redactParameter(*p.ParameterKey, after))
+		tw.EndLine()
+	}
+	if parameterChanged {
+		tw.EndLine()
+	}
+
+	fmt.Fprintf(tw, "Resources:\n")
+	tw.WriteColumn("  ACTION")
+	tw.WriteColumn("LOGICAL ID")
+	tw.WriteColumn("TYPE")
+	tw.WriteColumn("REPLACEMENT")
+	tw.WriteColumn("SCOPE")
+	tw.EndLine()
+	for _, change := range changes {
+		rc := change.ResourceChange
+		if rc == nil {
+			continue
+		}
+		tw.WriteColumn("  " + string(rc.Action))
+		tw.WriteColumn(aws.ToString(rc.LogicalResourceId))
+		tw.WriteColumn(aws.ToString(rc.ResourceType))
+		tw.WriteColumn(lo.Ternary(rc.Replacement == "", "-", string(rc.Replacement)))
+		tw.WriteColumn(strings.Join(lo.Map(rc.Scope, func(s types.ResourceAttribute, _ int) string {
+			return string(s)
+		}), ","))
+		tw.EndLine()
+	}
+
+	return tw.Flush()
+}
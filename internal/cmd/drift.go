@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+const driftDetectionTimeout = 15 * time.Minute
+
+var driftCmd = &cobra.Command{
+	Use:   "drift [stack]",
+	Short: "Detect changes made to stacks outside of CloudFormation",
+	Long: `Detect changes made to stacks outside of CloudFormation
+
+The drift command runs CloudFormation drift detection on the named stack, or on
+all configured stacks that have been deployed, and prints each resource that has
+been modified or deleted along with its property-level differences. It exits
+with a non-zero code if any stack has drifted or could not be checked.
+
+Without a stack name, drift skips stacks whose status does not allow drift
+detection, such as stacks that are being updated or failed to be created.
+
+Stacks configured with drift_check run the same detection before every deploy,
+which refuses to continue over drifted resources unless run with --ignore-drift.
+`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runDrift,
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+}
+
+// stackDrift is the result of drift detection for a single stack, including
+// only the resources that have drifted.
+type stackDrift struct {
+	StackName string
+	Status    types.StackDriftStatus
+	Resources []types.StackResourceDrift
+}
+
+func runDrift(cmd *cobra.Command, args []string) {
+	stacks := rootConfig.Stacks
+	if len(args) > 0 {
+		stack, ok := rootConfig.FindStack(args[0])
+		if !ok {
+			log.Fatalf("stack %s is not configured", args[0])
+		}
+		stacks = []config.StackConfig{stack}
+	}
+
+	ctx := context.Background()
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	var group errgroup.Group
+	group.SetLimit(maxConcurrentRequests)
+	drifts := make([]*stackDrift, len(stacks))
+	errs := make([]error, len(stacks))
+	for i, stack := range stacks {
+		group.Go(func() error {
+			description, err := describeStack(ctx, cfnClient, stack.Name)
+			switch {
+			case errors.Is(err, errStackNotExist) && len(args) == 0:
+				log.Printf("Skipping stack %s, which has not been deployed", stack.Name)
+				return nil
+			case err != nil:
+				errs[i] = err
+				return nil
+			case !isDriftDetectable(description.StackStatus) && len(args) == 0:
+				log.Printf("Skipping stack %s, whose status %s does not allow drift detection", stack.Name, description.StackStatus)
+				return nil
+			case !isDriftDetectable(description.StackStatus):
+				errs[i] = fmt.Errorf("stack %s has status %s, which does not allow drift detection", stack.Name, description.StackStatus)
+				return nil
+			}
+
+			log.Printf("Detecting drift for stack %s", stack.Name)
+			drift, err := detectStackDrift(ctx, cfnClient, stack.Name)
+			if err != nil {
+				errs[i] = fmt.Errorf("detecting drift for stack %s: %w", stack.Name, err)
+				return nil
+			}
+			drifts[i] = &drift
+			return nil
+		})
+	}
+	group.Wait()
+
+	var failed bool
+	for i, drift := range drifts {
+		if errs[i] != nil {
+			log.Print(errs[i])
+			failed = true
+		}
+		if drift != nil {
+			printStackDrift(os.Stdout, *drift)
+			failed = failed || drift.Status == types.StackDriftStatusDrifted
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// isDriftDetectable returns true if CloudFormation can detect drift on a stack
+// with the given status. Stacks that are being changed, or that failed to be
+// created, can't be checked.
+func isDriftDetectable(status types.StackStatus) bool {
+	switch status {
+	case types.StackStatusCreateComplete,
+		types.StackStatusUpdateComplete,
+		types.StackStatusUpdateRollbackComplete,
+		types.StackStatusUpdateRollbackFailed,
+		types.StackStatusImportComplete,
+		types.StackStatusImportRollbackComplete:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkStackDrift runs drift detection on the named stack if it exists, and
+// returns a non-nil error after printing the drifted resources if the stack has
+// drifted.
+func checkStackDrift(ctx context.Context, cfnClient *cloudformation.Client, stackName string) error {
+	stack, err := describeStack(ctx, cfnClient, stackName)
+	switch {
+	case errors.Is(err, errStackNotExist) || stack.StackStatus == types.StackStatusReviewInProgress:
+		return nil
+	case err != nil:
+		return err
+	case !isDriftDetectable(stack.StackStatus):
+		log.Printf("Skipping drift check for stack %s, whose status %s does not allow drift detection", stackName, stack.StackStatus)
+		return nil
+	}
+
+	log.Printf("Detecting drift for stack %s", stackName)
+	drift, err := detectStackDrift(ctx, cfnClient, stackName)
+	if err != nil {
+		return fmt.Errorf("detecting drift for stack %s: %w", stackName, err)
+	}
+	if drift.Status != types.StackDriftStatusDrifted {
+		return nil
+	}
+
+	printStackDrift(os.Stdout, drift)
+	return fmt.Errorf("stack %s has drifted from its template (use --ignore-drift to deploy anyway)", stackName)
+}
+
+// detectStackDrift runs drift detection on the named stack, waits for it to
+// finish, and returns the resources that have been modified or deleted.
+func detectStackDrift(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (stackDrift, error) {
+	detectOutput, err := cfnClient.DetectStackDrift(ctx, &cloudformation.DetectStackDriftInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return stackDrift{}, err
+	}
+
+	status, err := waitForDriftDetection(ctx, cfnClient, *detectOutput.StackDriftDetectionId)
+	if err != nil {
+		return stackDrift{}, err
+	}
+
+	// Detection "fails" if CloudFormation couldn't check some resources, but the
+	// results for all of the others are still valid.
+	if status.DetectionStatus == types.StackDriftDetectionStatusDetectionFailed {
+		log.Printf("Drift detection for stack %s was incomplete: %s",
+			stackName, aws.ToString(status.DetectionStatusReason))
+	}
+
+	drift := stackDrift{StackName: stackName, Status: status.StackDriftStatus}
+	paginator := cloudformation.NewDescribeStackResourceDriftsPaginator(cfnClient, &cloudformation.DescribeStackResourceDriftsInput{
+		StackName: aws.String(stackName),
+		StackResourceDriftStatusFilters: []types.StackResourceDriftStatus{
+			types.StackResourceDriftStatusModified,
+			types.StackResourceDriftStatusDeleted,
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return stackDrift{}, err
+		}
+		drift.Resources = append(drift.Resources, page.StackResourceDrifts...)
+	}
+	return drift, nil
+}
+
+// waitForDriftDetection polls the status of a drift detection operation until
+// it is no longer in progress.
+func waitForDriftDetection(ctx context.Context, cfnClient *cloudformation.Client, detectionID string) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, driftDetectionTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for drift detection: %w", ctx.Err())
+		case <-time.After(stackEventPollInterval):
+		}
+
+		status, err := cfnClient.DescribeStackDriftDetectionStatus(ctx, &cloudformation.DescribeStackDriftDetectionStatusInput{
+			StackDriftDetectionId: aws.String(detectionID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if status.DetectionStatus != types.StackDriftDetectionStatusDetectionInProgress {
+			return status, nil
+		}
+	}
+}
+
+// printStackDrift prints the drift status of a stack, followed by each drifted
+// resource and its property differences.
+func printStackDrift(w io.Writer, drift stackDrift) {
+	fmt.Fprintf(w, "%s: %s\n", drift.StackName, drift.Status)
+	for _, resource := range drift.Resources {
+		fmt.Fprintf(w, "  %s (%s) %s\n",
+			aws.ToString(resource.LogicalResourceId),
+			aws.ToString(resource.ResourceType),
+			resource.StackResourceDriftStatus)
+		for _, diff := range resource.PropertyDifferences {
+			fmt.Fprintf(w, "    %s: %s\n", aws.ToString(diff.PropertyPath), diff.DifferenceType)
+			fmt.Fprintf(w, "      expected: %s\n", aws.ToString(diff.ExpectedValue))
+			fmt.Fprintf(w, "      actual:   %s\n", aws.ToString(diff.ActualValue))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+const stackEventPollInterval = 5 * time.Second
+
+// stackEventStream reads new events for a stack as CloudFormation reports them.
+type stackEventStream struct {
+	cfnClient   *cloudformation.Client
+	stackName   string
+	lastEventID string
+}
+
+// newStackEventStream returns a stream whose first call to Next will return
+// only events that occur after the stream was created.
+func newStackEventStream(ctx context.Context, cfnClient *cloudformation.Client, stackName string) (*stackEventStream, error) {
+	s := &stackEventStream{cfnClient: cfnClient, stackName: stackName}
+	output, err := cfnClient.DescribeStackEvents(ctx, &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.StackEvents) > 0 {
+		s.lastEventID = *output.StackEvents[0].EventId
+	}
+	return s, nil
+}
+
+// Next returns the events that occurred since the previous call to Next, in
+// chronological order.
+func (s *stackEventStream) Next(ctx context.Context) ([]types.StackEvent, error) {
+	// CloudFormation returns events in reverse chronological order, so we page
+	// backward until we find the last event we've already seen.
+	var events []types.StackEvent
+	paginator := cloudformation.NewDescribeStackEventsPaginator(s.cfnClient, &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(s.stackName),
+	})
+pages:
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range page.StackEvents {
+			if *event.EventId == s.lastEventID {
+				break pages
+			}
+			events = append(events, event)
+		}
+	}
+
+	if len(events) > 0 {
+		s.lastEventID = *events[0].EventId
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// waitForStack streams events for a stack to the log until the stack reaches a
+// terminal status, and returns a non-nil error if the stack did not finish in a
+// successful status.
+func waitForStack(ctx context.Context, cfnClient *cloudformation.Client, events *stackEventStream) error {
+	ctx, cancel := context.WithTimeout(ctx, stackDeployTimeout)
+	defer cancel()
+
+	var firstFailure *types.StackEvent
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for stack %s: %w", events.stackName, ctx.Err())
+		case <-time.After(stackEventPollInterval):
+		}
+
+		// Read the stack status before the latest events, so that we're sure to
+		// have printed every event up to the terminal status before returning.
+		stack, err := describeStack(ctx, cfnClient, events.stackName)
+		if err != nil {
+			return err
+		}
+
+		newEvents, err := events.Next(ctx)
+		if err != nil {
+			return err
+		}
+		for _, event := range newEvents {
+			printStackEvent(event)
+			if firstFailure == nil && isRootCauseFailure(event) {
+				firstFailure = &event
+			}
+		}
+
+		status := string(stack.StackStatus)
+		if strings.HasSuffix(status, "_IN_PROGRESS") {
+			continue
+		}
+
+		switch stack.StackStatus {
+		case types.StackStatusCreateComplete, types.StackStatusUpdateComplete, types.StackStatusImportComplete:
+			return nil
+		}
+
+		if firstFailure != nil {
+			log.Printf("First failure: %s (%s) %s: %s",
+				aws.ToString(firstFailure.LogicalResourceId),
+				aws.ToString(firstFailure.ResourceType),
+				firstFailure.ResourceStatus,
+				aws.ToString(firstFailure.ResourceStatusReason))
+		}
+		return fmt.Errorf("stack %s ended in %s", events.stackName, status)
+	}
+}
+
+// printStackEvent logs a single stack event, highlighting failures if the log
+// is written to a terminal.
+func printStackEvent(event types.StackEvent) {
+	line := fmt.Sprintf("%s  %s  %s  %s",
+		event.Timestamp.Local().Format(time.TimeOnly),
+		aws.ToString(event.LogicalResourceId),
+		aws.ToString(event.ResourceType),
+		event.ResourceStatus)
+	if reason := aws.ToString(event.ResourceStatusReason); reason != "" {
+		line += "  " + reason
+	}
+
+	if isFailedEvent(event) && isTerminal(log.Writer()) {
+		line = "\x1b[31m" + line + "\x1b[0m"
+	}
+	log.Print(line)
+}
+
+func isFailedEvent(event types.StackEvent) bool {
+	return strings.HasSuffix(string(event.ResourceStatus), "_FAILED")
+}
+
+// isRootCauseFailure returns true for failed events that are not simply the
+// cancellation of a resource operation due to some other failure.
+func isRootCauseFailure(event types.StackEvent) bool {
+	reason := aws.ToString(event.ResourceStatusReason)
+	return isFailedEvent(event) &&
+		!strings.Contains(reason, "cancelled") &&
+		!strings.Contains(reason, "The following resource(s) failed")
+}
+
+// isTerminal returns true if w is a terminal (or, at least, a character
+// device that we'll assume is one).
+func isTerminal(w io.Writer) bool {
+	if rw, ok := w.(redactingWriter); ok {
+		w = rw.Writer
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
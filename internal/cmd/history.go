@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+// deployRecord is an entry in the local deployment history of a stack.
+type deployRecord struct {
+	Time time.Time `json:"time"`
+	// Parameters are the Lambda package parameters that the stack was deployed
+	// with.
+	Parameters map[string]string `json:"parameters"`
+	// Arch is the value of the stack's Architecture parameter, if its template
+	// declares one. Records from before hfc tracked this have no value.
+	Arch config.Arch `json:"arch,omitempty"`
+}
+
+// newDeployRecord returns a record of the Lambda package parameters and the
+// architecture that a stack is currently deployed with.
+func newDeployRecord(stack types.Stack) deployRecord {
+	arch, _ := getStackParameter(stack, "Architecture")
+	record := deployRecord{
+		Time:       time.Now().UTC(),
+		Parameters: make(map[string]string),
+		Arch:       config.Arch(arch),
+	}
+	for _, p := range stack.Parameters {
+		if isLambdaPackageParameter(*p.ParameterKey) {
+			record.Parameters[*p.ParameterKey] = *p.ParameterValue
+		}
+	}
+	return record
+}
+
+// isLambdaPackageParameter returns true if the named stack parameter identifies
+// a Lambda package.
+func isLambdaPackageParameter(key string) bool {
+	return strings.HasSuffix(key, "CodeS3Bucket") || strings.HasSuffix(key, "CodeS3Key")
+}
+
+// appendDeployHistory adds a record to the end of a stack's local deployment
+// history.
+func appendDeployHistory(stackName string, record deployRecord) error {
+	path := rootState.DeployHistoryPath(stackName)
+	if err := os.MkdirAll(filepath.Dir(path), fs.ModeDir|0755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readDeployHistory returns the local deployment history of a stack, from
+// oldest to newest. A stack that hfc has never deployed from this state
+// directory has an empty history.
+func readDeployHistory(stackName string) ([]deployRecord, error) {
+	file, err := os.Open(rootState.DeployHistoryPath(stackName))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	defer file.Close()
+
+	var history []deployRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record deployRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		history = append(history, record)
+	}
+	return history, scanner.Err()
+}
+
+// getPreviouslyDeployedS3Keys returns the S3 keys of all Lambda packages in the
+// local deployment history of any stack, mapped to the names of the stacks
+// they were deployed to.
+func getPreviouslyDeployedS3Keys() (map[string][]string, error) {
+	entries, err := os.ReadDir(rootState.DeployHistoryDir())
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	keys := make(map[string][]string)
+	for _, entry := range entries {
+		stackName, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		history, err := readDeployHistory(stackName)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range history {
+			for parameter, value := range record.Parameters {
+				if strings.HasSuffix(parameter, "CodeS3Key") && !slices.Contains(keys[value], stackName) {
+					keys[value] = append(keys[value], stackName)
+				}
+			}
+		}
+	}
+	return keys, nil
+}
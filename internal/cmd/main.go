@@ -35,6 +35,7 @@ var (
 )
 
 func initializePreRun(cmd *cobra.Command, args []string) {
+	log.SetOutput(redactingWriter{os.Stderr})
 	log.SetPrefix("[hfc] ")
 	log.SetFlags(0)
 	shelley.DefaultContext.DebugLogger = log.New(log.Writer(), "[hfc] $ ", 0)
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+)
+
+var outputsCmd = &cobra.Command{
+	Use:   "outputs [flags] stack",
+	Short: "Print the outputs of a CloudFormation stack",
+	Long: `Print the outputs of a CloudFormation stack
+
+The outputs command prints a stack's outputs as a table, as a JSON object, or in
+a form that local tools can load: a .env file with "env", or shell commands with
+"export" that can be evaluated in the current shell. For example:
+
+	eval "$(hfc outputs -o export MyStack)"
+
+Stacks configured with write_outputs also have their outputs written to the
+state directory in JSON and .env form after every deploy.
+`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runOutputs,
+}
+
+var outputsFormat string
+
+// outputFormats are the formats supported by writeStackOutputs.
+var outputFormats = []string{"text", "json", "env", "export"}
+
+func init() {
+	outputsCmd.Flags().StringVarP(&outputsFormat, "output", "o", "text", "output format (text, json, env, or export)")
+	rootCmd.AddCommand(outputsCmd)
+}
+
+func runOutputs(cmd *cobra.Command, args []string) {
+	if !slices.Contains(outputFormats, outputsFormat) {
+		log.Fatalf("unsupported output format %q", outputsFormat)
+	}
+
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	stack, err := describeStack(context.Background(), cfnClient, args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := writeStackOutputs(os.Stdout, stack.Outputs, outputsFormat); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// saveStackOutputs writes the outputs of a stack to the state directory in the
+// formats that local tools are most likely to read.
+func saveStackOutputs(stack types.Stack) error {
+	for _, format := range []string{"json", "env"} {
+		path := rootState.StackOutputsPath(*stack.StackName, format)
+		if err := os.MkdirAll(filepath.Dir(path), fs.ModeDir|0755); err != nil {
+			return err
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := writeStackOutputs(file, stack.Outputs, format); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStackOutputs writes stack outputs to w in one of the supported
+// outputFormats, sorted by key.
+func writeStackOutputs(w io.Writer, outputs []types.Output, format string) error {
+	outputs = slices.SortedFunc(slices.Values(outputs), func(a, b types.Output) int {
+		return strings.Compare(*a.OutputKey, *b.OutputKey)
+	})
+
+	switch format {
+	case "json":
+		values := make(map[string]string, len(outputs))
+		for _, output := range outputs {
+			values[*output.OutputKey] = aws.ToString(output.OutputValue)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(values)
+
+	case "env", "export":
+		prefix := ""
+		if format == "export" {
+			prefix = "export "
+		}
+		for _, output := range outputs {
+			value := shellQuote(aws.ToString(output.OutputValue))
+			if _, err := fmt.Fprintf(w, "%s%s=%s\n", prefix, *output.OutputKey, value); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "text":
+		tw := newTabWriter(w)
+		tw.WriteColumn("KEY")
+		tw.WriteColumn("VALUE")
+		tw.WriteColumn("DESCRIPTION")
+		tw.EndLine()
+		for _, output := range outputs {
+			tw.WriteColumn(*output.OutputKey)
+			tw.WriteColumn(aws.ToString(output.OutputValue))
+			tw.WriteColumn(aws.ToString(output.Description))
+			tw.EndLine()
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote returns s unchanged if it can be used as a word in a shell
+// command, or otherwise quotes it with single quotes.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
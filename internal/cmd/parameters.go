@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/samber/lo"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+// redacted replaces the values of secret parameters in hfc's output.
+const redacted = "****"
+
+// resolveStackParameters returns the values of the parameters in a stack's
+// configuration, reading the values of parameters with sources and the current
+// outputs of any stacks they refer to.
+func resolveStackParameters(ctx context.Context, cfnClient *cloudformation.Client, stack config.StackConfig) (map[string]string, error) {
+	parameters := make(map[string]string, len(stack.Parameters))
+	outputs := make(map[string][]types.Output)
+	for key, value := range stack.Parameters {
+		if !value.IsReference() {
+			resolved, secret, err := resolveParameterSource(ctx, value.Value)
+			if err != nil {
+				return nil, fmt.Errorf("resolving parameter %s: %w", key, err)
+			}
+			if secret || value.Secret {
+				addSecretParameter(key, resolved)
+			}
+			parameters[key] = resolved
+			continue
+		}
+
+		if _, ok := outputs[value.Stack]; !ok {
+			description, err := describeStack(ctx, cfnClient, value.Stack)
+			if err != nil {
+				return nil, fmt.Errorf("resolving parameter %s: %w", key, err)
+			}
+			outputs[value.Stack] = description.Outputs
+		}
+
+		output, ok := lo.Find(outputs[value.Stack], func(o types.Output) bool {
+			return aws.ToString(o.OutputKey) == value.Output
+		})
+		if !ok {
+			return nil, fmt.Errorf("resolving parameter %s: stack %s has no output %s", key, value.Stack, value.Output)
+		}
+		if value.Secret {
+			addSecretParameter(key, aws.ToString(output.OutputValue))
+		}
+		parameters[key] = aws.ToString(output.OutputValue)
+	}
+	return parameters, nil
+}
+
+// resolveParameterSource returns the value of a configured parameter, reading
+// it from the source named by its prefix if it has one. The value is secret if
+// its source is an encrypted SSM parameter.
+func resolveParameterSource(ctx context.Context, value string) (resolved string, secret bool, err error) {
+	source, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, false, nil
+	}
+
+	switch source {
+	case "ssm":
+		ssmClient := ssm.NewFromConfig(awsConfig)
+		output, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(ref),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", false, err
+		}
+		return aws.ToString(output.Parameter.Value), output.Parameter.Type == ssmtypes.ParameterTypeSecureString, nil
+
+	case "file":
+		contents, err := os.ReadFile(ref)
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimSpace(string(contents)), false, nil
+
+	case "env":
+		resolved, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", false, fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return resolved, false, nil
+
+	case "cmd":
+		output, err := commandOutput("sh", "-c", ref)
+		return output, false, err
+
+	default:
+		// Anything else, like a URL or an ARN, is a literal value that happens to
+		// contain a colon.
+		return value, false, nil
+	}
+}
+
+// secrets holds the keys and values of secret parameters resolved during this
+// run of hfc.
+var secrets struct {
+	sync.Mutex
+	keys   []string
+	values []string
+}
+
+// addSecretParameter marks a parameter's key and value as secret, so that hfc
+// redacts them from its output.
+func addSecretParameter(key, value string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.keys = append(secrets.keys, key)
+	if value != "" {
+		secrets.values = append(secrets.values, value)
+		// Replace longer values first, so that no part of a longer secret is left
+		// behind after replacing a shorter secret within it.
+		slices.SortFunc(secrets.values, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	}
+}
+
+// redactParameter returns the value of a parameter for display, which is fully
+// redacted if the parameter is secret.
+func redactParameter(key, value string) string {
+	secrets.Lock()
+	secret := slices.Contains(secrets.keys, key)
+	secrets.Unlock()
+	if secret {
+		return redacted
+	}
+	return redact(value)
+}
+
+// redact returns s with the values of all secret parameters replaced.
+func redact(s string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, value := range secrets.values {
+		s = strings.ReplaceAll(s, value, redacted)
+	}
+	return s
+}
+
+// redactingWriter redacts the values of all secret parameters from whole
+// writes, like the single lines written by a log.Logger.
+type redactingWriter struct {
+	io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.Writer, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
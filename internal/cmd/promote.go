@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/spf13/cobra"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote [flags] from-stack to-stack [parameters]",
+	Short: "Deploy a stack with the Lambda package currently used by another stack",
+	Long: `Deploy a stack with the Lambda package currently used by another stack
+
+The promote command reads the Lambda package that the source stack is currently
+deployed with, and deploys the target stack with that exact package rather than
+the latest upload. It refuses to deploy if the package no longer exists in S3.
+`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completePromoteStackNames,
+	PreRun:            initializePreRun,
+	Run:               runPromote,
+}
+
+func init() {
+	promoteCmd.Flags().BoolVar(&deployPreview, "preview", false, "print the changes that would be deployed, without deploying them")
+	promoteCmd.Flags().BoolVarP(&deployYes, "yes", "y", false, "deploy protected stacks without confirmation")
+	promoteCmd.Flags().BoolVar(&deployIgnoreDrift, "ignore-drift", false, "skip the drift check for stacks that enable it")
+	rootCmd.AddCommand(promoteCmd)
+}
+
+func runPromote(cmd *cobra.Command, args []string) {
+	fromStackName, toStackName := args[0], args[1]
+	toStack, ok := rootConfig.FindStack(toStackName)
+	if !ok {
+		log.Fatalf("stack %s is not configured", toStackName)
+	}
+
+	ctx := context.Background()
+	lambdaParameters, err := getPromotedPackageParameters(ctx, fromStackName, toStack)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	allParameters, err := getDeployParameters(toStack, lambdaParameters, args[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Promoting Lambda packages from %s to %s", fromStackName, toStackName)
+	deployStack(toStack, changeSetInput{Parameters: allParameters})
+}
+
+// getPromotedPackageParameters returns the Lambda package parameters that the
+// named stack is currently deployed with, after checking that the packages
+// still exist in S3 and match the target stack's architecture.
+func getPromotedPackageParameters(ctx context.Context, stackName string, target config.StackConfig) (map[string]string, error) {
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	stack, err := describeStack(ctx, cfnClient, stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without an Architecture parameter, assume the stack was deployed for the
+	// architecture it's configured with.
+	source, _ := rootConfig.FindStack(stackName)
+	arch := rootConfig.StackArch(source)
+	if value, ok := getStackParameter(stack, "Architecture"); ok {
+		arch = config.Arch(value)
+	}
+	if targetArch := rootConfig.StackArch(target); arch != targetArch {
+		return nil, fmt.Errorf("stack %s is deployed for %s, but stack %s uses %s", stackName, arch, target.Name, targetArch)
+	}
+
+	parameters := map[string]string{"Architecture": string(arch)}
+	for _, fn := range rootConfig.Build.AllFunctions() {
+		bucket, ok := getStackParameter(stack, fn.S3BucketParameter())
+		if !ok {
+			return nil, fmt.Errorf("stack %s deployed without %s parameter", stackName, fn.S3BucketParameter())
+		}
+		key, ok := getStackParameter(stack, fn.S3KeyParameter())
+		if !ok {
+			return nil, fmt.Errorf("stack %s deployed without %s parameter", stackName, fn.S3KeyParameter())
+		}
+		if err := checkPackageExists(ctx, bucket, key); err != nil {
+			return nil, err
+		}
+
+		log.Printf("Will promote s3://%s/%s", bucket, key)
+		parameters[fn.S3BucketParameter()] = bucket
+		parameters[fn.S3KeyParameter()] = key
+	}
+	return parameters, nil
+}
+
+func completePromoteStackNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 1 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return completeStackNames(cmd, nil, toComplete)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render stack",
+	Short: "Print the CloudFormation template as it would be deployed to a stack",
+	Long: `Print the CloudFormation template as it would be deployed to a stack
+
+When the template engine is "gotemplate", hfc renders the template as a Go
+text/template before deploying it to each stack. Templates can refer to:
+
+	.Config   the full hfc configuration
+	.Stack    the configuration of the stack being deployed
+	.Vars     the variables in the [template.vars] configuration
+	.Git      the current Git checkout, with .Commit, .ShortCommit, .Branch,
+	          .Describe, and .Dirty
+
+The render command prints the rendered template for review. Without a template
+engine, it prints the template unchanged.
+`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runRender,
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+}
+
+func runRender(cmd *cobra.Command, args []string) {
+	body, err := readTemplateBody(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stdout.Write(body); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// renderedTemplates caches templates rendered for each stack, so that a single
+// run of hfc deploys the same rendering that it validated.
+var renderedTemplates = make(map[string][]byte)
+
+// readTemplateBody returns the configured template as it should be deployed to
+// the named stack.
+func readTemplateBody(stackName string) ([]byte, error) {
+	switch rootConfig.Template.Engine {
+	case "":
+		return os.ReadFile(rootConfig.Template.Path)
+	case config.TemplateEngineGo:
+		if body, ok := renderedTemplates[stackName]; ok {
+			return body, nil
+		}
+		stack, ok := rootConfig.FindStack(stackName)
+		if !ok {
+			return nil, fmt.Errorf("stack %s is not configured", stackName)
+		}
+		body, err := renderTemplate(stack)
+		if err != nil {
+			return nil, err
+		}
+		renderedTemplates[stackName] = body
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported template engine %q", rootConfig.Template.Engine)
+	}
+}
+
+// templateData is the data available to templates rendered for a stack.
+type templateData struct {
+	Config config.Config
+	Stack  config.StackConfig
+	Vars   map[string]any
+	Git    gitMetadata
+}
+
+// renderTemplate renders the configured template as a Go text/template for a
+// stack, and writes the result to the state directory.
+func renderTemplate(stack config.StackConfig) ([]byte, error) {
+	path := rootConfig.Template.Path
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, templateData{
+		Config: rootConfig,
+		Stack:  stack,
+		Vars:   rootConfig.Template.Vars,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	renderedPath := rootState.RenderedTemplatePath(stack.Name, filepath.Ext(path))
+	if err := os.MkdirAll(filepath.Dir(renderedPath), fs.ModeDir|0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(renderedPath, body.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// gitMetadata provides information about the current Git checkout to rendered
+// templates. It only runs git for the information that a template uses.
+type gitMetadata struct{}
+
+func (gitMetadata) Commit() (string, error) {
+	return commandOutput("git", "rev-parse", "HEAD")
+}
+
+func (gitMetadata) ShortCommit() (string, error) {
+	return commandOutput("git", "rev-parse", "--short", "HEAD")
+}
+
+func (gitMetadata) Branch() (string, error) {
+	return commandOutput("git", "rev-parse", "--abbrev-ref", "HEAD")
+}
+
+func (gitMetadata) Describe() (string, error) {
+	return commandOutput("git", "describe", "--tags", "--always", "--dirty")
+}
+
+func (gitMetadata) Dirty() (bool, error) {
+	status, err := commandOutput("git", "status", "--porcelain")
+	return status != "", err
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+
+	"github.com/ahamlinman/hfc/internal/config"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [flags] stack [key]",
+	Short: "Redeploy the CloudFormation stack with a previous Lambda package",
+	Long: `Redeploy the CloudFormation stack with a previous Lambda package
+
+With only a stack name, the rollback command lists the Lambda packages that the
+stack could be rolled back to: those in hfc's local deployment history for the
+stack, and those currently uploaded under the configured prefix.
+
+With a key, the rollback command redeploys the stack with that package, keeping
+the stack's current template and all of its other parameter values. It refuses
+packages that the deployment history shows were deployed for a different
+architecture than the stack's current one.
+
+Projects with multiple Lambda functions must choose the function to roll back
+with --function, and only packages for that function are listed.
+`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: completeStackNames,
+	PreRun:            initializePreRun,
+	Run:               runRollback,
+}
+
+var rollbackFunction string
+
+func init() {
+	rollbackCmd.Flags().StringVar(&rollbackFunction, "function", "", "the name of the function to roll back")
+	rollbackCmd.Flags().BoolVar(&deployPreview, "preview", false, "print the changes that would be deployed, without deploying them")
+	rollbackCmd.Flags().BoolVarP(&deployYes, "yes", "y", false, "deploy protected stacks without confirmation")
+	rollbackCmd.Flags().BoolVar(&deployIgnoreDrift, "ignore-drift", false, "skip the drift check for stacks that enable it")
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+func runRollback(cmd *cobra.Command, args []string) {
+	stackName := args[0]
+	stack, ok := rootConfig.FindStack(stackName)
+	if !ok {
+		log.Fatalf("stack %s is not configured", stackName)
+	}
+
+	fn, err := findRollbackFunction()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) < 2 {
+		listRollbackCandidates(stack, fn)
+		return
+	}
+
+	ctx := context.Background()
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	description, err := describeStack(ctx, cfnClient, stackName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key := args[1]
+	bucket, ok := getStackParameter(description, fn.S3BucketParameter())
+	if !ok {
+		log.Fatalf("stack %s deployed without %s parameter", stackName, fn.S3BucketParameter())
+	}
+	if err := checkPackageExists(ctx, bucket, key); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkRollbackArch(description, fn, key); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Rolling back stack %s to s3://%s/%s", stackName, bucket, key)
+	deployStack(stack, changeSetInput{
+		Parameters:          map[string]string{fn.S3KeyParameter(): key},
+		UsePreviousTemplate: true,
+	})
+}
+
+// findRollbackFunction returns the configuration of the function selected for
+// rollback.
+func findRollbackFunction() (config.FunctionConfig, error) {
+	functions := rootConfig.Build.AllFunctions()
+	if rollbackFunction == "" {
+		if len(functions) > 1 {
+			return config.FunctionConfig{}, errors.New("must choose a function to roll back with --function")
+		}
+		return functions[0], nil
+	}
+
+	fn, ok := lo.Find(functions, func(fn config.FunctionConfig) bool { return fn.Name == rollbackFunction })
+	if !ok {
+		return config.FunctionConfig{}, fmt.Errorf("function %s is not configured", rollbackFunction)
+	}
+	return fn, nil
+}
+
+// checkRollbackArch returns a non-nil error if the local deployment history
+// shows that the package with the given key was deployed for a different
+// architecture than the stack's current Architecture parameter. Rollbacks keep
+// the stack's current parameters, so the package must match them.
+//
+// Packages without a recorded architecture, and stacks whose templates don't
+// declare an Architecture parameter, can't be checked.
+func checkRollbackArch(description types.Stack, fn config.FunctionConfig, key string) error {
+	stackArch, ok := getStackParameter(description, "Architecture")
+	if !ok {
+		return nil
+	}
+
+	history, err := readDeployHistory(*description.StackName)
+	if err != nil {
+		return err
+	}
+	for _, record := range slices.Backward(history) {
+		if record.Parameters[fn.S3KeyParameter()] != key || record.Arch == "" {
+			continue
+		}
+		if string(record.Arch) != stackArch {
+			return fmt.Errorf(
+				"%s was deployed for %s, but stack %s uses %s",
+				key, record.Arch, *description.StackName, stackArch)
+		}
+		return nil
+	}
+	return nil
+}
+
+// rollbackCandidate is a Lambda package that a stack could be rolled back to.
+type rollbackCandidate struct {
+	Key          string
+	Uploaded     time.Time
+	LastDeployed time.Time
+}
+
+func listRollbackCandidates(stack config.StackConfig, fn config.FunctionConfig) {
+	ctx := context.Background()
+	cfnClient := cloudformation.NewFromConfig(awsConfig)
+	s3Client := s3.NewFromConfig(awsConfig)
+
+	history, err := readDeployHistory(stack.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	objects, err := getUploadedS3Objects(ctx, s3Client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A missing stack or key just means there's no current package to mark.
+	currentKeys, _ := getStackS3Keys(ctx, cfnClient, stack.Name)
+	currentKey := currentKeys[fn.S3KeyParameter()]
+
+	candidates := make(map[string]*rollbackCandidate)
+	getCandidate := func(key string) *rollbackCandidate {
+		if c, ok := candidates[key]; ok {
+			return c
+		}
+		c := &rollbackCandidate{Key: key}
+		candidates[key] = c
+		return c
+	}
+	for _, object := range objects {
+		if isFunctionUploadKey(fn, *object.Key) {
+			getCandidate(*object.Key).Uploaded = *object.LastModified
+		}
+	}
+	for _, record := range history {
+		if key, ok := record.Parameters[fn.S3KeyParameter()]; ok {
+			getCandidate(key).LastDeployed = record.Time
+		}
+	}
+
+	sorted := make([]*rollbackCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		sorted = append(sorted, c)
+	}
+	slices.SortFunc(sorted, func(a, b *rollbackCandidate) int {
+		return cmp.Or(
+			latestTime(b.Uploaded, b.LastDeployed).Compare(latestTime(a.Uploaded, a.LastDeployed)),
+			cmp.Compare(b.Key, a.Key),
+		)
+	})
+
+	tw := newTabWriter(os.Stdout)
+	tw.WriteColumn("KEY")
+	tw.WriteColumn("UPLOADED")
+	tw.WriteColumn("LAST DEPLOYED")
+	tw.EndLine()
+	for _, c := range sorted {
+		tw.WriteColumn(c.Key)
+		tw.WriteColumn(formatCandidateTime(c.Uploaded, "(deleted)"))
+		tw.WriteColumn(formatCandidateTime(c.LastDeployed, "-"))
+		if c.Key == currentKey {
+			tw.WriteColumn("(current)")
+		}
+		tw.EndLine()
+	}
+	if err := tw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isFunctionUploadKey returns true if an uploaded object could be a Lambda
+// package for the function. Packages for named functions are only identifiable
+// by their timestamped keys, so content-addressed packages for named functions
+// only appear as rollback candidates through the deployment history.
+func isFunctionUploadKey(fn config.FunctionConfig, key string) bool {
+	if !isLambdaPackageKey(key) {
+		return false
+	}
+	if fn.Name == "" {
+		return true
+	}
+	name, ok := strings.CutPrefix(key, rootConfig.Upload.Prefix)
+	if !ok {
+		return false
+	}
+	timestamp, ok := strings.CutSuffix(name, "-"+fn.Name+".zip")
+	if !ok {
+		return false
+	}
+	_, err := strconv.ParseInt(timestamp, 10, 64)
+	return err == nil
+}
+
+func latestTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func formatCandidateTime(t time.Time, zero string) string {
+	if t.IsZero() {
+		return zero
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -1,102 +1,275 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
+	"slices"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
 	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/ahamlinman/hfc/internal/config"
 )
 
 var statusCmd = &cobra.Command{
-	Use:    "status",
-	Short:  "Summarize the deployment status of all stacks",
+	Use:   "status",
+	Short: "Summarize the deployment status of all stacks",
+	Long: `Summarize the deployment status of all stacks
+
+The status command prints the latest uploaded Lambda package, along with the
+package that each configured stack is deployed with, whether that package is the
+latest, and the stack's CloudFormation status and last update time. Stacks are
+flagged with (arch-mismatch) when either the latest package or the deployed
+stack was built for a different architecture than the stack is configured for.
+
+With --drift, status also prints the result of each stack's most recent drift
+detection. With --check, status exits with a non-zero code if any stack is not
+current or is in a failed CloudFormation status.
+`,
 	PreRun: initializePreRun,
 	Run:    runStatus,
 }
 
+var (
+	statusOutput string
+	statusDrift  bool
+	statusCheck  bool
+)
+
 func init() {
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "text", "output format (text, json, or yaml)")
+	statusCmd.Flags().BoolVar(&statusDrift, "drift", false, "include the result of the most recent drift detection")
+	statusCmd.Flags().BoolVar(&statusCheck, "check", false, "exit non-zero if any stack is not current or has failed")
 	rootCmd.AddCommand(statusCmd)
 }
 
+// stackStatus is the status of a single Lambda function in a single stack. For
+// projects with a single unnamed function, there is one per stack.
+//
+// If the deployed key is unknown, UnknownReason briefly explains why, and Error
+// may provide more detail.
+type stackStatus struct {
+	Stack         string     `json:"stack" yaml:"stack"`
+	Function      string     `json:"function,omitempty" yaml:"function,omitempty"`
+	DeployedKey   string     `json:"deployed_key,omitempty" yaml:"deployed_key,omitempty"`
+	LatestKey     string     `json:"latest_key,omitempty" yaml:"latest_key,omitempty"`
+	Current       bool       `json:"current" yaml:"current"`
+	ArchMismatch  bool       `json:"arch_mismatch,omitempty" yaml:"arch_mismatch,omitempty"`
+	StackStatus   string     `json:"stack_status,omitempty" yaml:"stack_status,omitempty"`
+	LastUpdated   *time.Time `json:"last_updated,omitempty" yaml:"last_updated,omitempty"`
+	DriftStatus   string     `json:"drift_status,omitempty" yaml:"drift_status,omitempty"`
+	UnknownReason string     `json:"unknown_reason,omitempty" yaml:"unknown_reason,omitempty"`
+	Error         string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Failed returns true if the stack is in a failed CloudFormation status,
+// including a status that indicates a rollback after a failure.
+func (s stackStatus) Failed() bool {
+	return strings.HasSuffix(s.StackStatus, "_FAILED") || strings.Contains(s.StackStatus, "ROLLBACK")
+}
+
 func runStatus(cmd *cobra.Command, args []string) {
-	const (
-		minwidth = 1
-		tabwidth = 8
-		padding  = 2
-		padchar  = ' '
-		flags    = 0
-	)
-	tw := tabWriter{
-		Writer: tabwriter.NewWriter(os.Stdout, minwidth, tabwidth, padding, padchar, flags),
+	if !slices.Contains([]string{"text", "json", "yaml"}, statusOutput) {
+		log.Fatalf("unsupported output format %q", statusOutput)
 	}
-	defer func() {
-		if err := tw.Flush(); err != nil {
+
+	functions := rootConfig.Build.AllFunctions()
+	latest := make([]latestPackage, len(functions))
+	for i, fn := range functions {
+		var err error
+		latest[i], err = readLatestLambdaPackage(fn)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
 			log.Fatal(err)
 		}
-	}()
-
-	latestPackageRaw, err := os.ReadFile(rootState.LatestLambdaPackagePath())
-	latestPackage := strings.TrimSpace(string(latestPackageRaw))
-	switch {
-	default:
-		tw.WriteColumn("(build)")
-		tw.WriteColumn(latestPackage)
-		tw.EndLine()
-	case errors.Is(err, fs.ErrNotExist):
-		tw.WriteColumn("(build)")
-		tw.WriteColumn("(none)")
-		tw.EndLine()
-	case err != nil:
-		log.Fatal(err)
-	}
-
-	if len(rootConfig.Stacks) == 0 {
-		return
 	}
 
 	cfnClient := cloudformation.NewFromConfig(awsConfig)
 	var group errgroup.Group
-	group.SetLimit(5) // TODO: This is arbitrary, is there a specific limit that makes sense?
-	stackS3Keys := make([]string, len(rootConfig.Stacks))
+	group.SetLimit(maxConcurrentRequests)
+	descriptions := make([]types.Stack, len(rootConfig.Stacks))
+	describeErrs := make([]error, len(rootConfig.Stacks))
 	for i, stack := range rootConfig.Stacks {
 		group.Go(func() error {
 			// Errors here are intentionally not hard failures. One misconfigured or
 			// not-yet-deployed stack should not prevent reporting for other stacks.
-			if key, err := getStackS3Key(context.Background(), cfnClient, stack.Name); err == nil {
-				stackS3Keys[i] = key
-			}
+			descriptions[i], describeErrs[i] = describeStack(context.Background(), cfnClient, stack.Name)
 			return nil
 		})
 	}
 	group.Wait()
 
+	statuses := make([]stackStatus, 0, len(rootConfig.Stacks)*len(functions))
 	for i, stack := range rootConfig.Stacks {
-		tw.WriteColumn(stack.Name)
+		for j, fn := range functions {
+			statuses = append(statuses, getStackStatus(stack, fn, latest[j], descriptions[i], describeErrs[i]))
+		}
+	}
 
-		key := stackS3Keys[i]
-		if key == "" {
-			tw.WriteColumn("(unknown)")
-			tw.EndLine()
-			continue
+	switch statusOutput {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			log.Fatal(err)
+		}
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(statuses); err != nil {
+			log.Fatal(err)
 		}
+	default:
+		printStatusTable(functions, latest, statuses)
+	}
 
-		tw.WriteColumn(key)
-		if key == latestPackage {
-			tw.WriteColumn("(current)")
+	if statusCheck {
+		for _, status := range statuses {
+			if !status.Current || status.Failed() {
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// getStackStatus summarizes the status of a function in a stack, given the
+// function's latest package and the result of describing the stack.
+func getStackStatus(
+	stack config.StackConfig,
+	fn config.FunctionConfig,
+	latest latestPackage,
+	description types.Stack,
+	describeErr error,
+) stackStatus {
+	status := stackStatus{
+		Stack:     stack.Name,
+		Function:  fn.Name,
+		LatestKey: latest.Key,
+		// The latest package can't be deployed to a stack for a different
+		// architecture, whether or not the stack exists yet.
+		ArchMismatch: latest.Key != "" && latest.Arch != rootConfig.StackArch(stack),
+	}
+	var apiErr smithy.APIError
+	switch {
+	case errors.Is(describeErr, errStackNotExist):
+		status.UnknownReason = "not deployed"
+		return status
+	case errors.As(describeErr, &apiErr) && strings.Contains(apiErr.ErrorCode(), "AccessDenied"):
+		status.UnknownReason = "access denied"
+		status.Error = describeErr.Error()
+		return status
+	case describeErr != nil:
+		status.UnknownReason = "error"
+		status.Error = describeErr.Error()
+		return status
+	}
+
+	status.StackStatus = string(description.StackStatus)
+	status.LastUpdated = cmp.Or(description.LastUpdatedTime, description.CreationTime)
+	if statusDrift && description.DriftInformation != nil {
+		status.DriftStatus = string(description.DriftInformation.StackDriftStatus)
+	}
+
+	key, ok := getStackParameter(description, fn.S3KeyParameter())
+	if !ok {
+		status.UnknownReason = "missing parameter"
+		status.Error = fmt.Sprintf("stack deployed without %s parameter", fn.S3KeyParameter())
+		return status
+	}
+	status.DeployedKey = key
+	status.Current = key == latest.Key
+
+	// Templates without an Architecture parameter can't tell us what the stack
+	// was deployed for, so we can only flag mismatches in the others.
+	arch, _ := getStackParameter(description, "Architecture")
+	status.ArchMismatch = status.ArchMismatch || (arch != "" && arch != string(rootConfig.StackArch(stack)))
+	return status
+}
+
+func printStatusTable(functions []config.FunctionConfig, latest []latestPackage, statuses []stackStatus) {
+	tw := newTabWriter(os.Stdout)
+	defer func() {
+		if err := tw.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	for i, fn := range functions {
+		tw.WriteColumn(functionLabel("(build)", fn))
+		if latest[i].Key == "" {
+			tw.WriteColumn("(none)")
 		} else {
+			tw.WriteColumn(latest[i].Key)
+			tw.WriteColumn("(" + string(latest[i].Arch) + ")")
+		}
+		tw.EndLine()
+	}
+
+	for _, status := range statuses {
+		tw.WriteColumn(functionLabel(status.Stack, config.FunctionConfig{Name: status.Function}))
+
+		switch {
+		case status.DeployedKey == "":
+			tw.WriteColumn("(unknown)")
+			tw.WriteColumn("(" + status.UnknownReason + ")")
+		case status.Current:
+			tw.WriteColumn(status.DeployedKey)
+			tw.WriteColumn("(current)")
+		default:
+			tw.WriteColumn(status.DeployedKey)
 			tw.WriteColumn("(not-current)")
 		}
+
+		if status.StackStatus != "" {
+			tw.WriteColumn(status.StackStatus)
+			tw.WriteColumn(status.LastUpdated.Local().Format(time.DateTime))
+		}
+		if status.DriftStatus != "" {
+			tw.WriteColumn("drift:" + status.DriftStatus)
+		}
+		if status.ArchMismatch {
+			tw.WriteColumn("(arch-mismatch)")
+		}
 		tw.EndLine()
 	}
 }
 
+// functionLabel returns a label for a row of status output that applies to a
+// specific function.
+func functionLabel(label string, fn config.FunctionConfig) string {
+	if fn.Name == "" {
+		return label
+	}
+	return label + "/" + fn.Name
+}
+
+// newTabWriter returns a tabWriter that aligns the columns of hfc's tables.
+func newTabWriter(w io.Writer) *tabWriter {
+	const (
+		minwidth = 1
+		tabwidth = 8
+		padding  = 2
+		padchar  = ' '
+		flags    = 0
+	)
+	return &tabWriter{
+		Writer: tabwriter.NewWriter(w, minwidth, tabwidth, padding, padchar, flags),
+	}
+}
+
 type tabWriter struct {
 	*tabwriter.Writer
 	inLine bool
@@ -3,20 +3,30 @@ package cmd
 import (
 	"archive/zip"
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha256"
+	"debug/elf"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"io"
+	"fmt"
 	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ahamlinman/hfc/internal/cfn"
+	"github.com/ahamlinman/hfc/internal/config"
 )
 
 var uploadCmd = &cobra.Command{
@@ -31,27 +41,61 @@ func init() {
 }
 
 func runUpload(cmd *cobra.Command, args []string) {
-	outputPath, err := rootState.BinaryPath(rootConfig.Project.Name)
-	if err != nil {
+	group, ctx := errgroup.WithContext(context.Background())
+	for _, fn := range rootConfig.Build.AllFunctions() {
+		group.Go(func() error { return uploadFunction(ctx, fn) })
+	}
+	if err := group.Wait(); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	log.Print("Building deployment package")
+// uploadFunction uploads a deployment package for the latest build of a
+// function, and records it as the function's latest package.
+func uploadFunction(ctx context.Context, fn config.FunctionConfig) error {
+	outputPath, err := functionBinaryPath(fn)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Building %s", describePackage(fn))
 	lambdaPackage, err := createLambdaPackage(outputPath)
 	if err != nil {
-		log.Fatalf("failed to create deployment package: %v", err)
+		return fmt.Errorf("failed to create %s: %w", describePackage(fn), err)
+	}
+
+	arch, err := getBinaryArch(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read binary architecture: %w", err)
+	}
+
+	name := strconv.FormatInt(time.Now().Unix(), 10)
+	if fn.Name != "" {
+		name += "-" + fn.Name
 	}
 
 	var (
 		s3Client   = s3.NewFromConfig(awsConfig)
 		bucket     = rootConfig.Upload.Bucket
-		key        = rootConfig.Upload.Prefix + strconv.FormatInt(time.Now().Unix(), 10) + ".zip"
+		key        = rootConfig.Upload.Prefix + name + ".zip"
 		hashBytes  = sha256.Sum256(lambdaPackage)
 		hashString = base64.StdEncoding.EncodeToString(hashBytes[:])
 	)
 
-	log.Printf("Uploading deployment package to s3://%s/%s", bucket, key)
-	_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+	if rootConfig.Upload.ContentAddressed {
+		key = rootConfig.Upload.Prefix + hex.EncodeToString(hashBytes[:]) + ".zip"
+		exists, err := objectExists(ctx, bucket, key)
+		if err != nil {
+			return fmt.Errorf("failed to check for existing %s: %w", describePackage(fn), err)
+		}
+		if exists {
+			log.Printf("The %s already exists at s3://%s/%s", describePackage(fn), bucket, key)
+			return writeLatestLambdaPackage(fn, latestPackage{Key: key, Arch: arch})
+		}
+	}
+
+	log.Printf("Uploading %s to s3://%s/%s", describePackage(fn), bucket, key)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:         aws.String(bucket),
 		Key:            aws.String(key),
 		Body:           bytes.NewReader(lambdaPackage),
@@ -59,11 +103,202 @@ func runUpload(cmd *cobra.Command, args []string) {
 		ChecksumSHA256: aws.String(hashString),
 	})
 	if err != nil {
-		log.Fatalf("failed to upload deployment package: %v", err)
+		return fmt.Errorf("failed to upload %s: %w", describePackage(fn), err)
 	}
 
-	if err := os.WriteFile(rootState.LatestLambdaPackagePath(), append([]byte(key), '\n'), 0644); err != nil {
-		log.Fatal(err)
+	return writeLatestLambdaPackage(fn, latestPackage{Key: key, Arch: arch})
+}
+
+// maxTemplateBodySize is the size in bytes of the largest template body that
+// CloudFormation accepts directly, rather than from S3.
+const maxTemplateBodySize = 51200
+
+// These key prefixes distinguish uploaded templates, and the local artifacts
+// that templates refer to, from Lambda packages in the upload prefix.
+const (
+	templateKeyPrefix = "template-"
+	artifactKeyPrefix = "artifact-"
+)
+
+// templateKey returns the S3 key for an uploaded template, which is named by
+// the SHA-256 hash of its body.
+func templateKey(body []byte) string {
+	return contentKey(templateKeyPrefix, body, cmp.Or(filepath.Ext(rootConfig.Template.Path), ".template"))
+}
+
+// contentKey returns an S3 key in the upload prefix that is named by the
+// SHA-256 hash of an object's content.
+func contentKey(kindPrefix string, body []byte, ext string) string {
+	hash := sha256.Sum256(body)
+	return rootConfig.Upload.Prefix + kindPrefix + hex.EncodeToString(hash[:]) + ext
+}
+
+// isTemplateKey returns true if the S3 key in the upload prefix is for an
+// uploaded template.
+func isTemplateKey(key string) bool {
+	name, ok := strings.CutPrefix(key, rootConfig.Upload.Prefix)
+	return ok && strings.HasPrefix(name, templateKeyPrefix)
+}
+
+// isArtifactKey returns true if the S3 key in the upload prefix is for a local
+// artifact that a template refers to.
+func isArtifactKey(key string) bool {
+	name, ok := strings.CutPrefix(key, rootConfig.Upload.Prefix)
+	return ok && strings.HasPrefix(name, artifactKeyPrefix)
+}
+
+// isLambdaPackageKey returns true if the S3 key in the upload prefix is for a
+// Lambda package, rather than for another kind of upload.
+func isLambdaPackageKey(key string) bool {
+	return !isTemplateKey(key) && !isArtifactKey(key)
+}
+
+// uploadTemplate uploads a template body to the upload bucket, unless the same
+// template was already uploaded, and returns the template's URL.
+func uploadTemplate(ctx context.Context, body []byte) (string, error) {
+	key := templateKey(body)
+	templateURL, err := s3ObjectURL(ctx, rootConfig.Upload.Bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if err := uploadContent(ctx, key, body, "template"); err != nil {
+		return "", err
+	}
+	return templateURL, nil
+}
+
+// packageTemplate uploads the local files and directories that a template
+// refers to, including nested templates, and returns the template with
+// references to the uploads in their place.
+func packageTemplate(ctx context.Context, body []byte) ([]byte, error) {
+	dir := filepath.Dir(rootConfig.Template.Path)
+	return cfn.Package(body, dir, func(artifact cfn.Artifact) (cfn.Location, error) {
+		kindPrefix := lo.Ternary(artifact.Template, templateKeyPrefix, artifactKeyPrefix)
+		key := contentKey(kindPrefix, artifact.Content, artifact.Ext)
+		objectURL, err := s3ObjectURL(ctx, rootConfig.Upload.Bucket, key)
+		if err != nil {
+			return cfn.Location{}, err
+		}
+		if err := uploadContent(ctx, key, artifact.Content, artifact.Path); err != nil {
+			return cfn.Location{}, err
+		}
+		return cfn.Location{
+			Bucket: rootConfig.Upload.Bucket,
+			Key:    key,
+			URL:    objectURL,
+		}, nil
+	})
+}
+
+// uploadContent uploads an object with a content-addressed key to the upload
+// bucket, unless the object already exists. The description of the object is
+// used in log and error messages.
+func uploadContent(ctx context.Context, key string, body []byte, description string) error {
+	var (
+		s3Client   = s3.NewFromConfig(awsConfig)
+		bucket     = rootConfig.Upload.Bucket
+		hashBytes  = sha256.Sum256(body)
+		hashString = base64.StdEncoding.EncodeToString(hashBytes[:])
+	)
+
+	if bucket == "" {
+		return fmt.Errorf("must configure an upload bucket to upload %s", description)
+	}
+
+	exists, err := objectExists(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("failed to check for existing %s: %w", description, err)
+	}
+	if exists {
+		return nil
+	}
+
+	log.Printf("Uploading %s to s3://%s/%s", description, bucket, key)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(key),
+		Body:           bytes.NewReader(body),
+		ContentLength:  aws.Int64(int64(len(body))),
+		ChecksumSHA256: aws.String(hashString),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", description, err)
+	}
+	return nil
+}
+
+// s3ObjectURL returns the HTTPS URL of an S3 object in the current region, as
+// resolved by the S3 client. The URL is path-style, since virtual-hosted-style
+// URLs don't work over HTTPS for bucket names that contain dots.
+func s3ObjectURL(ctx context.Context, bucket, key string) (string, error) {
+	if awsConfig.Region == "" {
+		return "", errors.New("must configure an AWS region to deploy from S3")
+	}
+	endpoint, err := s3.NewDefaultEndpointResolverV2().ResolveEndpoint(ctx, s3.EndpointParameters{
+		Bucket:         aws.String(bucket),
+		Region:         aws.String(awsConfig.Region),
+		Endpoint:       awsConfig.BaseEndpoint,
+		ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("resolving S3 endpoint: %w", err)
+	}
+	return endpoint.URI.JoinPath(key).String(), nil
+}
+
+// describePackage returns a description of a function's deployment package for
+// log messages.
+func describePackage(fn config.FunctionConfig) string {
+	if fn.Name == "" {
+		return "deployment package"
+	}
+	return "deployment package for " + fn.Name
+}
+
+// latestPackage describes the latest uploaded Lambda package.
+type latestPackage struct {
+	Key  string
+	Arch config.Arch
+}
+
+// readLatestLambdaPackage reads the latest uploaded Lambda package for a
+// function from the state directory. If no package has been uploaded, the error
+// satisfies errors.Is(err, fs.ErrNotExist).
+func readLatestLambdaPackage(fn config.FunctionConfig) (latestPackage, error) {
+	raw, err := os.ReadFile(rootState.LatestLambdaPackagePath(fn.Name))
+	if err != nil {
+		return latestPackage{}, err
+	}
+
+	// The architecture was added to the file after the key, and packages from
+	// before that were always built for arm64.
+	key, arch, _ := strings.Cut(strings.TrimSpace(string(raw)), "\n")
+	return latestPackage{
+		Key:  strings.TrimSpace(key),
+		Arch: cmp.Or(config.Arch(strings.TrimSpace(arch)), config.ArchARM64),
+	}, nil
+}
+
+func writeLatestLambdaPackage(fn config.FunctionConfig, latest latestPackage) error {
+	contents := latest.Key + "\n" + string(latest.Arch) + "\n"
+	return os.WriteFile(rootState.LatestLambdaPackagePath(fn.Name), []byte(contents), 0644)
+}
+
+// getBinaryArch returns the architecture that a Linux binary was built for.
+func getBinaryArch(path string) (config.Arch, error) {
+	binary, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer binary.Close()
+
+	switch binary.Machine {
+	case elf.EM_AARCH64:
+		return config.ArchARM64, nil
+	case elf.EM_X86_64:
+		return config.ArchX86_64, nil
+	default:
+		return "", fmt.Errorf("unsupported machine type %v", binary.Machine)
 	}
 }
 
@@ -79,11 +314,7 @@ func createLambdaPackage(handlerPath string) ([]byte, error) {
 
 	var output bytes.Buffer
 	zipWriter := zip.NewWriter(&output)
-	handlerWriter, err := zipWriter.Create("bootstrap")
-	if err != nil {
-		return nil, err
-	}
-	if _, err := io.Copy(handlerWriter, handlerBinary); err != nil {
+	if err := cfn.AddZipFile(zipWriter, "bootstrap", handlerBinary, true); err != nil {
 		return nil, err
 	}
 	if err := zipWriter.Close(); err != nil {
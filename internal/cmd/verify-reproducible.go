@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ahamlinman/hfc/internal/shelley"
+)
+
+var verifyReproducibleCmd = &cobra.Command{
+	Use:   "verify-reproducible",
+	Short: "Check that two builds produce identical deployment packages",
+	Long: `Check that two builds produce identical deployment packages
+
+The verify-reproducible command fully rebuilds the Go binary twice, creates a
+deployment package from each build, and compares their SHA-256 hashes. It exits
+with a non-zero status if the packages differ.
+
+Builds are far more likely to be reproducible with reproducible = true in the
+hfc build configuration.
+`,
+	PreRun: initializePreRun,
+	Run:    runVerifyReproducible,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyReproducibleCmd)
+}
+
+func runVerifyReproducible(cmd *cobra.Command, args []string) {
+	if !rootConfig.Build.Reproducible {
+		log.Print("Reproducible builds are not enabled in the build configuration")
+	}
+
+	verifyDir := rootState.Path("verify")
+	if err := os.RemoveAll(verifyDir); err != nil {
+		log.Fatal("cleaning verification directory: ", err)
+	}
+	defer os.RemoveAll(verifyDir)
+
+	functions := rootConfig.Build.AllFunctions()
+	var hashes [2][]string
+	for i := range hashes {
+		log.Printf("Starting build %d of %d", i+1, len(hashes))
+		for _, fn := range functions {
+			outputPath := filepath.Join(verifyDir, fmt.Sprint(i+1), cmp.Or(fn.Name, rootConfig.Project.Name))
+			if err := os.MkdirAll(filepath.Dir(outputPath), fs.ModeDir|0755); err != nil {
+				log.Fatal("creating output directory: ", err)
+			}
+
+			// Force a full rebuild, so the second build can't just reuse the first.
+			shelley.ExitIfError(buildBinary(fn, outputPath, rootConfig.BuildArch(), "-a"))
+
+			lambdaPackage, err := createLambdaPackage(outputPath)
+			if err != nil {
+				log.Fatalf("failed to create %s: %v", describePackage(fn), err)
+			}
+			hash := sha256.Sum256(lambdaPackage)
+			hashes[i] = append(hashes[i], hex.EncodeToString(hash[:]))
+			log.Printf("Build %d %s SHA-256: %s", i+1, describePackage(fn), hashes[i][len(hashes[i])-1])
+		}
+	}
+
+	if !slices.Equal(hashes[0], hashes[1]) {
+		log.Fatal("Builds are not reproducible")
+	}
+	log.Print("Builds are reproducible")
+}
@@ -18,7 +18,7 @@ const (
 )
 
 // Load automatically loads the full configuration by finding, loading, and
-// merging the base and local configurations.
+// merging the base and local configurations, and validates the result.
 func Load() (Config, error) {
 	baseConfigPath, err := FindPath()
 	if err != nil {
@@ -39,7 +39,11 @@ func Load() (Config, error) {
 		}
 	}
 
-	return Merge(baseConfig, localConfig), nil
+	config := Merge(baseConfig, localConfig)
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
 }
 
 // FindPath returns the rooted path to the configuration file in the current
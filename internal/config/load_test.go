@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -10,27 +11,81 @@ func TestLoad(t *testing.T) {
 	want := Config{
 		Project: ProjectConfig{
 			Name: "hfc",
+			StackDefaults: StackSettings{
+				Tags: map[string]string{
+					"Project":    "hfc",
+					"CostCenter": "engineering",
+				},
+				RoleARN:          "arn:aws:iam::123456789012:role/hfc-deploy",
+				NotificationARNs: []string{"arn:aws:sns:us-west-2:123456789012:hfc-events"},
+			},
 		},
 		AWS: AWSConfig{
 			Region: "us-west-2",
 		},
 		Build: BuildConfig{
-			Path: "./cmd/hfc",
-			Tags: []string{"grpcnotrace"},
+			Path:         "./cmd/hfc",
+			Tags:         []string{"grpcnotrace"},
+			Arch:         ArchX86_64,
+			Reproducible: true,
+			Functions: []FunctionConfig{{
+				Name: "Api",
+				Path: "./cmd/hfc-api",
+			}, {
+				Name: "Worker",
+				Path: "./cmd/hfc-worker",
+				Tags: []string{"worker"},
+			}},
 		},
 		Upload: UploadConfig{
-			Bucket: "hfc",
+			Bucket:           "hfc",
+			ContentAddressed: true,
+			Retention: RetentionConfig{
+				KeepLatest:              5,
+				KeepNewerThan:           30 * 24 * time.Hour,
+				KeepPreviousDeployments: true,
+			},
 		},
 		Template: TemplateConfig{
 			Path:         "CloudFormation.yaml",
 			Capabilities: []string{"CAPABILITY_IAM"},
+			Engine:       TemplateEngineGo,
+			Vars: map[string]any{
+				"Runtime":    "provided.al2023",
+				"MemorySize": int64(256),
+			},
+			Upload: true,
 		},
 		Stacks: []StackConfig{{
-			Name:       "HFCStaging",
-			Parameters: map[string]string{"Environment": "staging"},
+			Name: "HFCStaging",
+			Parameters: map[string]ParameterValue{
+				"Environment":    {Value: "staging"},
+				"ReleaseVersion": {Value: "cmd:git describe --always"},
+				"SlackToken":     {Value: "ssm:/hfc/staging/slack-token", Secret: true},
+			},
+			WriteOutputs: true,
+			Arch:         ArchARM64,
+			StackSettings: StackSettings{
+				Tags:                  map[string]string{"Environment": "staging"},
+				TerminationProtection: ptr(false),
+			},
 		}, {
-			Name:       "HFCProduction",
-			Parameters: map[string]string{"Environment": "production"},
+			Name: "HFCProduction",
+			Parameters: map[string]ParameterValue{
+				"Environment":   {Value: "production"},
+				"StagingApiUrl": {Stack: "HFCStaging", Output: "ApiUrl"},
+				"StagingApiKey": {Stack: "HFCStaging", Output: "ApiKey", Secret: true},
+			},
+			Protected:  true,
+			DriftCheck: true,
+			StackSettings: StackSettings{
+				Tags: map[string]string{
+					"Environment": "production",
+					"CostCenter":  "operations",
+				},
+				TerminationProtection: ptr(true),
+				StackPolicy:           "policies/production.json",
+			},
 		}},
 	}
 
@@ -45,3 +100,7 @@ func TestLoad(t *testing.T) {
 		t.Errorf("unexpected result (-want +got):\n%s", diff)
 	}
 }
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -1,6 +1,17 @@
 package config
 
-import "github.com/samber/lo"
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
 
 // Config represents a full configuration.
 type Config struct {
@@ -12,16 +23,116 @@ type Config struct {
 	Stacks   []StackConfig  `toml:"stacks"`
 }
 
+// Validate returns a non-nil error if the configuration is invalid.
+func (c *Config) Validate() error {
+	return c.Build.validateFunctions()
+}
+
 // FindStack searches for the stack with the given name. If no stack is defined
 // with the provided name, FindStack returns ok == false.
 func (c *Config) FindStack(name string) (stack StackConfig, ok bool) {
 	return lo.Find(c.Stacks, func(s StackConfig) bool { return s.Name == name })
 }
 
+// BuildArch returns the architecture to build for when no stack is specified.
+func (c *Config) BuildArch() Arch {
+	return cmp.Or(c.Build.Arch, ArchARM64)
+}
+
+// StackArch returns the architecture to build and deploy for the provided
+// stack, which defaults to the architecture in the build configuration.
+func (c *Config) StackArch(stack StackConfig) Arch {
+	return cmp.Or(stack.Arch, c.BuildArch())
+}
+
+// DependsOn returns the names of the configured stacks whose outputs the stack's
+// parameters refer to, in sorted order.
+func (c *Config) DependsOn(stack StackConfig) []string {
+	var names []string
+	for _, value := range stack.Parameters {
+		if !value.IsReference() {
+			continue
+		}
+		if _, ok := c.FindStack(value.Stack); ok {
+			names = append(names, value.Stack)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// DeployOrder returns all configured stacks in an order where each stack comes
+// after the stacks that its parameters refer to, or an error if the references
+// form a cycle. Stacks that are otherwise unordered keep their configured order.
+func (c *Config) DeployOrder() ([]StackConfig, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(c.Stacks))
+	order := make([]StackConfig, 0, len(c.Stacks))
+
+	var visit func(stack StackConfig, path []string) error
+	visit = func(stack StackConfig, path []string) error {
+		path = append(path, stack.Name)
+		switch state[stack.Name] {
+		case visiting:
+			return fmt.Errorf("stack parameters form a cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[stack.Name] = visiting
+		for _, name := range c.DependsOn(stack) {
+			dependency, _ := c.FindStack(name)
+			if err := visit(dependency, path); err != nil {
+				return err
+			}
+		}
+		state[stack.Name] = visited
+		order = append(order, stack)
+		return nil
+	}
+
+	for _, stack := range c.Stacks {
+		if err := visit(stack, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// StackSettings returns the stack settings to apply when deploying the provided
+// stack, which default to the stack defaults in the project configuration.
+// The stack's tags are used in addition to the default tags, and override
+// default tags with the same keys.
+func (c *Config) StackSettings(stack StackConfig) StackSettings {
+	defaults := c.Project.StackDefaults
+	settings := StackSettings{
+		RoleARN:               cmp.Or(stack.RoleARN, defaults.RoleARN),
+		NotificationARNs:      defaults.NotificationARNs,
+		TerminationProtection: cmp.Or(stack.TerminationProtection, defaults.TerminationProtection),
+		StackPolicy:           cmp.Or(stack.StackPolicy, defaults.StackPolicy),
+	}
+	if len(stack.NotificationARNs) > 0 {
+		settings.NotificationARNs = stack.NotificationARNs
+	}
+	if len(defaults.Tags) > 0 || len(stack.Tags) > 0 {
+		settings.Tags = make(map[string]string, len(defaults.Tags)+len(stack.Tags))
+		maps.Copy(settings.Tags, defaults.Tags)
+		maps.Copy(settings.Tags, stack.Tags)
+	}
+	return settings
+}
+
 // ProjectConfig represents the configuration for this project, which is
 // expected to be common across all possible deployments.
+//
+// StackDefaults are the settings for any stack that does not set them itself.
 type ProjectConfig struct {
-	Name string `toml:"name"`
+	Name          string        `toml:"name"`
+	StackDefaults StackSettings `toml:"stack_defaults"`
 }
 
 // AWSConfig represents the configuration for all AWS operations in this
@@ -30,30 +141,258 @@ type AWSConfig struct {
 	Region string `toml:"region"`
 }
 
-// BuildConfig represents the configuration for building a deployable Go binary.
+// BuildConfig represents the configuration for building deployable Go binaries.
+//
+// When Reproducible is set, the build omits file system paths and the build ID
+// from the binary, so that builds of the same source produce identical output.
 type BuildConfig struct {
+	Path         string           `toml:"path"`
+	Tags         []string         `toml:"tags"`
+	Arch         Arch             `toml:"arch"`
+	Reproducible bool             `toml:"reproducible"`
+	Functions    []FunctionConfig `toml:"functions"`
+}
+
+// AllFunctions returns the configurations of all Lambda functions to build. If
+// the build configuration does not define any functions, AllFunctions returns a
+// single unnamed function with the path from the build configuration.
+func (b *BuildConfig) AllFunctions() []FunctionConfig {
+	if len(b.Functions) == 0 {
+		return []FunctionConfig{{Path: b.Path}}
+	}
+	return b.Functions
+}
+
+// validateFunctions returns a non-nil error unless every function has a unique
+// alphanumeric name. Function names become part of template parameter names
+// and of file names in the state directory, so no two functions can share one.
+func (b *BuildConfig) validateFunctions() error {
+	var errs []error
+	seen := make(map[string]bool, len(b.Functions))
+	for i, fn := range b.Functions {
+		switch {
+		case fn.Name == "":
+			errs = append(errs, fmt.Errorf("build function %d must have a name", i+1))
+		case !functionNamePattern.MatchString(fn.Name):
+			errs = append(errs, fmt.Errorf("build function name %q must only contain letters and digits", fn.Name))
+		case seen[fn.Name]:
+			errs = append(errs, fmt.Errorf("build function name %q is used more than once", fn.Name))
+		}
+		seen[fn.Name] = true
+	}
+	return errors.Join(errs...)
+}
+
+var functionNamePattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// FunctionConfig represents the configuration for building one of the Lambda
+// functions in a project. Its tags are used in addition to the tags in the
+// build configuration.
+//
+// Each function's package is passed to the template through parameters named
+// with the function's name as a prefix, e.g. ApiCodeS3Bucket and ApiCodeS3Key
+// for a function named "Api". The unnamed function's parameters are simply
+// CodeS3Bucket and CodeS3Key.
+type FunctionConfig struct {
+	Name string   `toml:"name"`
 	Path string   `toml:"path"`
 	Tags []string `toml:"tags"`
 }
 
+// S3BucketParameter returns the name of the template parameter for the S3
+// bucket containing the function's package.
+func (f FunctionConfig) S3BucketParameter() string {
+	return f.Name + "CodeS3Bucket"
+}
+
+// S3KeyParameter returns the name of the template parameter for the S3 key of
+// the function's package.
+func (f FunctionConfig) S3KeyParameter() string {
+	return f.Name + "CodeS3Key"
+}
+
+// Arch is an instruction set architecture supported by AWS Lambda, named as
+// Lambda names it.
+type Arch string
+
+const (
+	ArchARM64  Arch = "arm64"
+	ArchX86_64 Arch = "x86_64"
+)
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting either the Go
+// or the Lambda name for each architecture.
+func (a *Arch) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "arm64":
+		*a = ArchARM64
+	case "x86_64", "amd64":
+		*a = ArchX86_64
+	default:
+		return fmt.Errorf("unsupported architecture %q", text)
+	}
+	return nil
+}
+
+// GOARCH returns the value of GOARCH that builds binaries for the architecture.
+func (a Arch) GOARCH() string {
+	if a == ArchX86_64 {
+		return "amd64"
+	}
+	return "arm64"
+}
+
 // UploadConfig represents the configuration for uploading a Go binary in a
 // Lambda .zip archive to an Amazon S3 bucket.
+//
+// When ContentAddressed is set, uploads are named by the SHA-256 hash of the
+// archive rather than the upload time, and archives that already exist in the
+// bucket are not uploaded again.
 type UploadConfig struct {
-	Bucket string `toml:"bucket"`
-	Prefix string `toml:"prefix"`
+	Bucket           string          `toml:"bucket"`
+	Prefix           string          `toml:"prefix"`
+	ContentAddressed bool            `toml:"content_addressed"`
+	Retention        RetentionConfig `toml:"retention"`
+}
+
+// RetentionConfig represents the rules for keeping uploads that are not in use
+// by any stack when cleaning up the upload bucket. An upload is kept if any
+// rule applies to it.
+type RetentionConfig struct {
+	// KeepLatest is the number of most recent uploads to keep.
+	KeepLatest int `toml:"keep_latest"`
+	// KeepNewerThan is the age below which uploads are kept.
+	KeepNewerThan time.Duration `toml:"keep_newer_than"`
+	// KeepPreviousDeployments keeps uploads that hfc has previously deployed to
+	// any stack, according to the local deployment history.
+	KeepPreviousDeployments bool `toml:"keep_previous_deployments"`
 }
 
 // TemplateConfig represents the configuration of the AWS CloudFormation
 // template associated with the deployment.
+//
+// When Engine is "gotemplate", the template is rendered for each stack as a Go
+// text/template before it is deployed, with access to Vars among other data.
+//
+// Templates too large for CloudFormation to accept directly are uploaded to the
+// upload bucket before they are deployed. When Upload is set, all templates are
+// uploaded regardless of size.
 type TemplateConfig struct {
-	Path         string   `toml:"path"`
-	Capabilities []string `toml:"capabilities"`
+	Path         string         `toml:"path"`
+	Capabilities []string       `toml:"capabilities"`
+	Engine       string         `toml:"engine"`
+	Vars         map[string]any `toml:"vars"`
+	Upload       bool           `toml:"upload"`
 }
 
+// TemplateEngineGo is the template engine that renders templates as Go
+// text/templates.
+const TemplateEngineGo = "gotemplate"
+
 // StackConfig represents the configuration of an AWS CloudFormation stack, a
 // specific deployment of the CloudFormation template with a unique set of
 // parameters.
+//
+// Deployments to protected stacks require interactive confirmation. Stacks
+// with DriftCheck set are checked for drift before each deployment, which fails
+// if any resource has drifted from the template. Stacks with WriteOutputs set
+// have their outputs written to the state directory after each deployment.
+//
+// The embedded StackSettings override the stack defaults in the project
+// configuration (see Config.StackSettings).
 type StackConfig struct {
-	Name       string            `toml:"name"`
-	Parameters map[string]string `toml:"parameters"`
+	Name         string                    `toml:"name"`
+	Parameters   map[string]ParameterValue `toml:"parameters"`
+	Protected    bool                      `toml:"protected"`
+	DriftCheck   bool                      `toml:"drift_check"`
+	WriteOutputs bool                      `toml:"write_outputs"`
+	Arch         Arch                      `toml:"arch"`
+	StackSettings
+}
+
+// StackSettings represents the settings that CloudFormation applies to a stack
+// itself, rather than to its resources, on every deployment.
+type StackSettings struct {
+	// Tags are applied to the stack, and propagated by CloudFormation to the
+	// stack's resources.
+	Tags map[string]string `toml:"tags"`
+	// RoleARN is the ARN of the IAM service role that CloudFormation assumes to
+	// deploy the stack.
+	RoleARN string `toml:"role_arn"`
+	// NotificationARNs are the ARNs of the SNS topics that receive the stack's
+	// events.
+	NotificationARNs []string `toml:"notification_arns"`
+	// TerminationProtection, if set, enables or disables termination protection
+	// for the stack. A nil value leaves the stack's setting unchanged.
+	TerminationProtection *bool `toml:"termination_protection"`
+	// StackPolicy is the path to a JSON stack policy file to set on the stack.
+	StackPolicy string `toml:"stack_policy"`
+}
+
+// ParameterValue represents the value of a stack parameter, which is one of:
+//
+//   - A literal string.
+//   - A string with a source prefix, whose value is read at deploy time:
+//     "ssm:/name" for an SSM parameter, "file:path" for the trimmed contents of a
+//     file, "env:NAME" for an environment variable, or "cmd:command" for the
+//     trimmed output of a shell command.
+//   - A table { value = "...", secret = true } with either of the above, whose
+//     value hfc redacts from its output.
+//   - A reference to an output of another stack, written as
+//     { stack = "StackName", output = "OutputKey" }, optionally with
+//     secret = true.
+type ParameterValue struct {
+	Value  string
+	Secret bool
+	Stack  string
+	Output string
+}
+
+// IsReference returns true if the value refers to another stack's output.
+func (p ParameterValue) IsReference() bool {
+	return p.Stack != ""
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting either a string or a
+// table with a value or a stack output reference.
+func (p *ParameterValue) UnmarshalTOML(data any) error {
+	switch data := data.(type) {
+	case string:
+		*p = ParameterValue{Value: data}
+		return nil
+	case map[string]any:
+		return p.unmarshalTable(data)
+	default:
+		return fmt.Errorf("parameter must be a string or a table, got %T", data)
+	}
+}
+
+func (p *ParameterValue) unmarshalTable(data map[string]any) error {
+	*p = ParameterValue{}
+	for key, value := range data {
+		var ok bool
+		switch key {
+		case "value":
+			p.Value, ok = value.(string)
+		case "secret":
+			p.Secret, ok = value.(bool)
+		case "stack":
+			p.Stack, ok = value.(string)
+		case "output":
+			p.Output, ok = value.(string)
+		default:
+			return fmt.Errorf("unknown parameter key %q", key)
+		}
+		if !ok {
+			return fmt.Errorf("invalid type %T for parameter key %q", value, key)
+		}
+	}
+
+	switch {
+	case p.IsReference() && (p.Output == "" || p.Value != ""):
+		return fmt.Errorf("parameter reference to stack %s must have an output and no value", p.Stack)
+	case !p.IsReference() && p.Output != "":
+		return fmt.Errorf("parameter reference to output %s must have a stack", p.Output)
+	}
+	return nil
 }
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/samber/lo"
+)
+
+func TestDeployOrder(t *testing.T) {
+	ref := func(stack string) ParameterValue {
+		return ParameterValue{Stack: stack, Output: "Output"}
+	}
+	config := Config{
+		Stacks: []StackConfig{{
+			Name: "App",
+			Parameters: map[string]ParameterValue{
+				"Queue": ref("Queue"),
+				"Vpc":   ref("Network"),
+			},
+		}, {
+			Name:       "Queue",
+			Parameters: map[string]ParameterValue{"Vpc": ref("Network")},
+		}, {
+			Name:       "Network",
+			Parameters: map[string]ParameterValue{"Account": ref("Unconfigured")},
+		}, {
+			Name:       "Unrelated",
+			Parameters: map[string]ParameterValue{"Environment": {Value: "test"}},
+		}},
+	}
+
+	order, err := config.DeployOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := lo.Map(order, func(s StackConfig, _ int) string { return s.Name })
+	want := []string{"Network", "Queue", "App", "Unrelated"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeployOrderCycle(t *testing.T) {
+	config := Config{
+		Stacks: []StackConfig{{
+			Name:       "A",
+			Parameters: map[string]ParameterValue{"B": {Stack: "B", Output: "Output"}},
+		}, {
+			Name:       "B",
+			Parameters: map[string]ParameterValue{"A": {Stack: "A", Output: "Output"}},
+		}},
+	}
+
+	_, err := config.DeployOrder()
+	const want = "stack parameters form a cycle: A -> B -> A"
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error; got %v, want %q", err, want)
+	}
+}
+
+func TestStackSettings(t *testing.T) {
+	config := Config{
+		Project: ProjectConfig{
+			StackDefaults: StackSettings{
+				Tags:                  map[string]string{"Project": "hfc", "Owner": "platform"},
+				RoleARN:               "arn:aws:iam::123456789012:role/default",
+				NotificationARNs:      []string{"arn:aws:sns:us-west-2:123456789012:default"},
+				TerminationProtection: ptr(true),
+			},
+		},
+		Stacks: []StackConfig{{
+			Name: "Defaults",
+		}, {
+			Name: "Overrides",
+			StackSettings: StackSettings{
+				Tags:                  map[string]string{"Owner": "payments"},
+				RoleARN:               "arn:aws:iam::123456789012:role/overrides",
+				NotificationARNs:      []string{"arn:aws:sns:us-west-2:123456789012:overrides"},
+				TerminationProtection: ptr(false),
+				StackPolicy:           "policy.json",
+			},
+		}},
+	}
+
+	want := map[string]StackSettings{
+		"Defaults": config.Project.StackDefaults,
+		"Overrides": {
+			Tags:                  map[string]string{"Project": "hfc", "Owner": "payments"},
+			RoleARN:               "arn:aws:iam::123456789012:role/overrides",
+			NotificationARNs:      []string{"arn:aws:sns:us-west-2:123456789012:overrides"},
+			TerminationProtection: ptr(false),
+			StackPolicy:           "policy.json",
+		},
+	}
+	for _, stack := range config.Stacks {
+		got := config.StackSettings(stack)
+		if diff := cmp.Diff(want[stack.Name], got); diff != "" {
+			t.Errorf("unexpected settings for %s (-want +got):\n%s", stack.Name, diff)
+		}
+	}
+}
+
+func TestValidateFunctions(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Names       []string
+		WantError   bool
+	}{
+		{"no functions", nil, false},
+		{"unique names", []string{"Api", "Worker2"}, false},
+		{"empty name", []string{"Api", ""}, true},
+		{"duplicate name", []string{"Api", "Api"}, true},
+		{"path in name", []string{"../x"}, true},
+		{"punctuation in name", []string{"api-v2"}, true},
+	}
+	for _, tc := range testCases {
+		config := Config{
+			Build: BuildConfig{
+				Functions: lo.Map(tc.Names, func(name string, _ int) FunctionConfig {
+					return FunctionConfig{Name: name}
+				}),
+			},
+		}
+		err := config.Validate()
+		if (err != nil) != tc.WantError {
+			t.Errorf("%s: got error %v, want error %v", tc.Description, err, tc.WantError)
+		}
+	}
+}
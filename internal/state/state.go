@@ -48,9 +48,38 @@ func (s State) BinaryPath(name string) (string, error) {
 }
 
 // LatestLambdaPackagePath returns the absolute path to the file containing the
-// S3 key of the latest Lambda deployment package.
-func (s State) LatestLambdaPackagePath() string {
-	return s.Path("latest-lambda-package")
+// S3 key of the latest Lambda deployment package for the named function, or
+// for the project's only function if the name is empty.
+func (s State) LatestLambdaPackagePath(function string) string {
+	if function == "" {
+		return s.Path("latest-lambda-package")
+	}
+	return s.Path("latest-lambda-package-" + function)
+}
+
+// DeployHistoryDir returns the absolute path to the directory containing the
+// local deployment history of all stacks.
+func (s State) DeployHistoryDir() string {
+	return s.Path("history")
+}
+
+// DeployHistoryPath returns the absolute path to the file containing the local
+// deployment history of the named stack.
+func (s State) DeployHistoryPath(stackName string) string {
+	return filepath.Join(s.DeployHistoryDir(), stackName+".jsonl")
+}
+
+// StackOutputsPath returns the absolute path to the file containing the outputs
+// of the named stack in the provided format, which is used as the file's
+// extension.
+func (s State) StackOutputsPath(stackName, format string) string {
+	return s.Path("outputs", stackName+"."+format)
+}
+
+// RenderedTemplatePath returns the absolute path to the file containing the
+// template rendered for the named stack, with the provided file extension.
+func (s State) RenderedTemplatePath(stackName, ext string) string {
+	return s.Path("rendered", stackName+ext)
 }
 
 // Path returns the absolute file path formed by joining the provided path